@@ -0,0 +1,124 @@
+package main
+
+import (
+	"authservice/pkg/store"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const adminUsage string = `usage:
+  main                          run service
+  main revoke-sessions <GUID>   revoke all sessions of user
+  main migrate up               apply pending schema migrations
+  main migrate down [steps]     revert latest schema migrations, one by default
+  main migrate status           list schema migrations
+`
+
+// runCommand runs administrative command passed as service binary arguments
+func runCommand(args []string) error {
+	switch args[0] {
+	case "revoke-sessions":
+		if len(args) != 2 {
+			return fmt.Errorf("revoke-sessions expects GUID\n%v", adminUsage)
+		}
+
+		sessions, err := openSessionStore()
+
+		if err != nil {
+			return err
+		}
+
+		defer sessions.Close()
+
+		revoked, err := sessions.RevokeUser(args[1], "", clock.Now())
+
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("revoked %v sessions of user %v\n", revoked, args[1])
+
+		return nil
+	case "migrate":
+		if len(args) < 2 {
+			return fmt.Errorf("migrate expects up, down or status\n%v", adminUsage)
+		}
+
+		sessions, err := openSessionStore()
+
+		if err != nil {
+			return err
+		}
+
+		defer sessions.Close()
+
+		migrator, ok := sessions.(store.Migrator)
+
+		if !ok {
+			return fmt.Errorf("session store has no schema to migrate")
+		}
+
+		return runMigrate(migrator, args[1:])
+	default:
+		return fmt.Errorf("unknown command: %v\n%v", args[0], adminUsage)
+	}
+}
+
+// runMigrate runs migrate subcommand
+func runMigrate(migrator store.Migrator, args []string) error {
+	switch args[0] {
+	case "up":
+		applied, err := migrator.MigrateUp()
+
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("applied %v migrations\n", applied)
+
+		return nil
+	case "down":
+		steps := 1
+
+		if len(args) > 1 {
+			value, err := strconv.Atoi(args[1])
+
+			if err != nil || value < 1 {
+				return fmt.Errorf("migrate down expects positive number of steps, got: %v", args[1])
+			}
+
+			steps = value
+		}
+
+		reverted, err := migrator.MigrateDown(steps)
+
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("reverted %v migrations\n", reverted)
+
+		return nil
+	case "status":
+		status, err := migrator.MigrationStatus()
+
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range status {
+			state := "pending"
+
+			if migration.Applied {
+				state = "applied at " + migration.AppliedAt.Format(time.RFC3339)
+			}
+
+			fmt.Printf("%04d %v: %v\n", migration.Version, migration.Name, state)
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command: %v\n%v", args[0], adminUsage)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"authservice/pkg/store"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrateCommand(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.db")
+
+	t.Setenv("SESSION_STORE", SessionStoreSQLite)
+	t.Setenv("SQLITE_PATH", path)
+
+	status := func() []store.MigrationStatus {
+		sessions, err := store.OpenSQLite(path)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer sessions.Close()
+
+		status, err := sessions.MigrationStatus()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return status
+	}
+
+	countApplied := func() int {
+		applied := 0
+
+		for _, migration := range status() {
+			if migration.Applied {
+				applied++
+			}
+		}
+
+		return applied
+	}
+
+	if err := runCommand([]string{"migrate", "up"}); err != nil {
+		t.Fatal(err)
+	}
+
+	total := len(status())
+
+	if applied := countApplied(); applied != total {
+		t.Fatalf("expected %v applied migrations, got %v", total, applied)
+	}
+
+	if err := runCommand([]string{"migrate", "down"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if applied := countApplied(); applied != total-1 {
+		t.Fatalf("expected %v applied migrations after down, got %v", total-1, applied)
+	}
+
+	if err := runCommand([]string{"migrate", "status"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range [][]string{{"migrate"}, {"migrate", "sideways"}, {"migrate", "down", "0"}} {
+		if err := runCommand(args); err == nil {
+			t.Fatalf("expected error for %v", args)
+		}
+	}
+
+	sessions, err := openSessionStore()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer sessions.Close()
+
+	t.Setenv("AUTO_MIGRATE", "false")
+
+	if err := migrateOnStart(sessions); err != nil {
+		t.Fatal(err)
+	}
+
+	if applied := countApplied(); applied != total-1 {
+		t.Fatalf("migrations were applied with AUTO_MIGRATE=false")
+	}
+
+	t.Setenv("AUTO_MIGRATE", "")
+
+	if err := migrateOnStart(sessions); err != nil {
+		t.Fatal(err)
+	}
+
+	if applied := countApplied(); applied != total {
+		t.Fatalf("migrations were not applied on start")
+	}
+
+	t.Setenv("SESSION_STORE", SessionStoreMemory)
+
+	if err := runCommand([]string{"migrate", "up"}); err == nil {
+		t.Fatalf("memory store was migrated")
+	}
+}
@@ -3,8 +3,11 @@ package main
 import (
 	api "authservice/pkg/api"
 	"authservice/pkg/auth"
-	"database/sql"
+	"authservice/pkg/events"
+	"authservice/pkg/mail"
+	"authservice/pkg/store"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -13,34 +16,76 @@ import (
 	"github.com/google/uuid"
 )
 
-func generateAccessRefreshTokens(ip string, session string) (accessToken api.AccessToken, refreshToken api.RefreshToken, err error) {
-	accessToken = api.NewAccessToken(time.Now().Add(AccessTokenDuration), session)
+// generateAccessRefreshTokens issues token pair of session, expires is moment Refresh token expires at
+// Access token is bound to DPoP proof key or client certificate with confirmation unless it is nil
+func generateAccessRefreshTokens(ip string, GUID string, session string, generation int, expires time.Time, confirmation *api.Confirmation) (accessToken api.AccessToken, refreshToken api.SessionRefreshToken, err error) {
+	now := clock.Now()
 
-	err = auth.SignAccessToken(&accessToken, secret)
+	accessToken = api.NewAccessToken(now.Add(AccessTokenDuration), session)
+	accessToken.Payload.Subject = GUID
+	accessToken.Payload.Issuer = tokenIssuer
+	accessToken.Payload.Audience = tokenAudience
+	accessToken.Payload.IssuedAt = now.Unix()
+	accessToken.Payload.NotBefore = now.Unix()
+	accessToken.Payload.ID = uuid.New().String()
+	accessToken.Payload.Scope = tokenScope
+
+	accessToken.Payload.Confirmation = confirmation
+
+	err = keyring.SignAccessToken(&accessToken, accessTokenFormat != AccessTokenFormatLegacy)
 
 	if err != nil {
 		err = fmt.Errorf("failed to sign Access token when Refresh Access token pair generation: %w", err)
 		return
 	}
 
-	refreshToken = api.NewRefreshToken(accessToken.Signature, time.Now().Add(RefreshTokenDuration), ip)
+	if refreshTokenFormat == RefreshTokenFormatOpaque {
+		refreshToken, err = api.NewOpaqueRefreshToken(session, generation)
+
+		if err != nil {
+			err = fmt.Errorf("failed to generate Refresh token when Refresh Access token pair generation: %w", err)
+			return
+		}
+
+		return accessToken, refreshToken, nil
+	}
+
+	legacyToken := api.NewRefreshToken(accessToken.Signature, expires, ip)
+	legacyToken.Payload.Session = session
+	legacyToken.Payload.Generation = generation
 
-	return accessToken, refreshToken, nil
+	return accessToken, legacyToken, nil
 }
 
-func makeTokenPair(accessToken api.AccessToken, refreshToken api.RefreshToken) (tokenPair api.RefreshAccessTokenPair, err error) {
-	refreshTokenBase64, err := refreshToken.Base64()
+// sessionConfirmation returns DPoP key and client certificate tokens of session are bound to, nil for bearer sessions
+func sessionConfirmation(session store.Session) *api.Confirmation {
+	if session.DPoPJKT == "" && session.CertThumbprint == "" {
+		return nil
+	}
+
+	return &api.Confirmation{JKT: session.DPoPJKT, X5TS256: session.CertThumbprint}
+}
+
+func makeTokenPair(accessToken api.AccessToken, refreshToken api.SessionRefreshToken) (tokenPair api.RefreshAccessTokenPair, err error) {
+	encodedRefreshToken, err := refreshToken.Encode()
 
 	tokenPair = api.RefreshAccessTokenPair{
-		RefreshToken: refreshTokenBase64,
+		RefreshToken: encodedRefreshToken,
 		AccessToken:  accessToken,
+		TokenType:    api.TokenTypeBearer,
+	}
+
+	if accessToken.Payload.Confirmation != nil && accessToken.Payload.Confirmation.JKT != "" {
+		tokenPair.TokenType = api.TokenTypeDPoP
 	}
 
 	return
 }
 
-func generateAccessRefreshPair(ip string, session string) (tokenPair api.RefreshAccessTokenPair, err error) {
-	accessToken, refreshToken, err := generateAccessRefreshTokens(ip, session)
+func generateAccessRefreshPair(ip string, GUID string, session string) (tokenPair api.RefreshAccessTokenPair, err error) {
+	now := clock.Now()
+
+	accessToken, refreshToken, err := generateAccessRefreshTokens(ip, GUID, session, 0, defaultSessionPolicy.Expires(now, now), nil)
 
 	if err != nil {
 		err = fmt.Errorf("Refresh token base64 encoding error when Refresh Access token pair generation: %w", err)
@@ -81,7 +126,27 @@ func validateAuthRequest(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
-func newHandleAuth(DB *sql.DB) func(w http.ResponseWriter, r *http.Request) {
+// reportEvictedSessions raises security events and warns user about sessions revoked to make room for new one
+func reportEvictedSessions(evicted []store.Session, session store.Session, mailer mail.Mailer, publisher events.Publisher, now time.Time) {
+	for _, old := range evicted {
+		details := fmt.Sprintf("session was evicted by new session %v, limit of active sessions is %v", session.ID, sessionLimit.Max)
+
+		publisher.Publish(events.Event{
+			Type:    events.SessionEvicted,
+			GUID:    old.GUID,
+			Session: old.ID,
+			Ip:      session.Ip,
+			Time:    now,
+			Details: details,
+		})
+
+		// TODO add user email from DB
+		msg := fmt.Sprintf("warning session was revoked after login from another device.\nDevice: %v\nUser agent: %v\nNew ip: %v", old.DeviceName, old.UserAgent, session.Ip)
+		mailer.SendWarning("authwarning@example.com", "user@example.com", msg)
+	}
+}
+
+func newHandleAuth(sessions store.SessionStore, mailer mail.Mailer, publisher events.Publisher) func(w http.ResponseWriter, r *http.Request) {
 
 	return func(w http.ResponseWriter, r *http.Request) {
 
@@ -91,44 +156,45 @@ func newHandleAuth(DB *sql.DB) func(w http.ResponseWriter, r *http.Request) {
 
 		GUID := r.Header.Get("Guid")
 		session := uuid.New().String()
+		clientID := sessionClient(r)
 
 		ip := r.RemoteAddr
 
-		accessToken, refreshToken, err := generateAccessRefreshTokens(ip, session)
+		// Session is bound to key of DPoP proof, then its tokens are refreshed and used only with proofs of same key
+		jkt := ""
 
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			log.Default().Printf("error when trying to generate Refresh Access token pair: %v\n", err)
-			return
-		}
+		if dpopRequired || r.Header.Get(auth.DPoPHeader) != "" {
+			proof, ok := readDPoPProof(w, r, "")
 
-		hash, err := refreshToken.Hash(GUID)
+			if !ok {
+				return
+			}
 
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			log.Default().Printf("error when trying to calculate hash for refresh token: %v\n", err)
-			return
+			jkt = proof.Thumbprint
 		}
 
-		tx, err := DB.Begin()
+		// Session is bound to client certificate when service runs with TLS client authentication
+		certThumbprint := auth.ClientCertificateThumbprint(r)
+		userAgent, deviceName := clientMetadata(r)
+
+		now := clock.Now()
+		expires := sessionPolicy(clientID).Expires(now, now)
+
+		confirmation := sessionConfirmation(store.Session{DPoPJKT: jkt, CertThumbprint: certThumbprint})
+
+		accessToken, refreshToken, err := generateAccessRefreshTokens(ip, GUID, session, 0, expires, confirmation)
 
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
-			log.Default().Printf("error when trying to begin transaction: %v\n", err)
+			log.Default().Printf("error when trying to generate Refresh Access token pair: %v\n", err)
 			return
 		}
 
-		defer func() {
-			if err != nil {
-				tx.Rollback()
-			}
-		}()
-
-		err = AddSession(tx, hash, GUID, session, refreshToken.Header.Expires)
+		hash, hasherID, err := hashRefreshToken(refreshToken, GUID)
 
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
-			log.Default().Printf("error when trying to add new session: %v\n", err)
+			log.Default().Printf("error when trying to calculate hash for refresh token: %v\n", err)
 			return
 		}
 
@@ -148,12 +214,38 @@ func newHandleAuth(DB *sql.DB) func(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if err = tx.Commit(); err != nil {
+		created := store.Session{
+			ID:             session,
+			GUID:           GUID,
+			TokenHash:      hash,
+			TokenHasher:    hasherID,
+			ExpiresAt:      expires,
+			Ip:             ip,
+			CreatedAt:      now,
+			ClientID:       clientID,
+			DPoPJKT:        jkt,
+			CertThumbprint: certThumbprint,
+			UserAgent:      userAgent,
+			DeviceName:     deviceName,
+		}
+
+		// Limit is checked and session is added atomically, so concurrent logins can not exceed it
+		evicted, err := sessions.CreateLimited(created, sessionLimit, now)
+
+		if errors.Is(err, store.ErrSessionLimit) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("too many active sessions"))
+			return
+		}
+
+		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
-			log.Default().Printf("error when trying to commit new session: %v\n", err)
+			log.Default().Printf("error when trying to add new session: %v\n", err)
 			return
 		}
 
+		reportEvictedSessions(evicted, created, mailer, publisher, now)
+
 		w.Header().Set("Content-Type", "application/json")
 		w.Write(answerJson)
 	}
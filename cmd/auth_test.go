@@ -2,56 +2,75 @@ package main
 
 import (
 	api "authservice/pkg/api"
-	"database/sql"
+	"authservice/pkg/auth"
+	"authservice/pkg/events"
+	"authservice/pkg/store"
 	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
-func CreateTestingBD() (*sql.DB, error) {
-	DB, err := sql.Open("sqlite3", ":memory:")
+// testingSecret is HMAC secret of keyring used by tests
+const testingSecret string = "secret used only in tests"
+
+// testingIntrospectionSecret authenticates resource server in introspection tests
+const testingIntrospectionSecret string = "resource server secret"
+
+// testNow is fixed current time of tests using fixClock
+var testNow time.Time = time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+// fixClock makes clock return time now points to until test ends, test moves clock by changing it
+func fixClock(t *testing.T, now *time.Time) {
+	clock = auth.ClockFunc(func() time.Time { return *now })
+	t.Cleanup(func() { clock = auth.SystemClock{} })
+}
+
+// newTestingKeyring creates keyring with single signing key
+func newTestingKeyring(signer auth.Signer) (*auth.Keyring, error) {
+	kid, err := auth.KeyID(signer)
 
 	if err != nil {
 		return nil, err
 	}
 
-	defer func() {
-		if err != nil {
-			DB.Close()
-		}
-	}()
+	return auth.NewKeyring(kid, signer), nil
+}
+
+func TestMain(m *testing.M) {
+	signer, err := auth.NewHMACSigner(auth.AlgHS512, []byte(testingSecret))
 
-	tx, err := DB.Begin()
 	if err != nil {
-		return nil, err
+		panic(err)
 	}
 
-	defer func() {
-		if err != nil {
-			tx.Rollback()
-		}
-	}()
+	if keyring, err = newTestingKeyring(signer); err != nil {
+		panic(err)
+	}
 
-	_, err = tx.Exec(`CREATE TABLE sessions (
-		session_id TEXT PRIMARY KEY,
-		GUID TEXT NOT NULL, 
-		token_hash TEXT NOT NULL, 
-		expires_at TIME)
-		`)
+	introspectionSecret = testingIntrospectionSecret
+
+	os.Exit(m.Run())
+}
+
+// CreateTestingStore opens SQLite session store in memory with schema created by migrations
+func CreateTestingStore() (*store.SQLStore, error) {
+	sessions, err := store.OpenSQLite(":memory:")
 
 	if err != nil {
 		return nil, err
 	}
 
-	if err = tx.Commit(); err != nil {
+	if _, err = sessions.MigrateUp(); err != nil {
+		sessions.Close()
 		return nil, err
 	}
 
-	return DB, nil
-
+	return sessions, nil
 }
 
 func TestAuthOk(t *testing.T) {
@@ -66,13 +85,13 @@ func TestAuthOk(t *testing.T) {
 
 	request.Header.Add("Guid", "hello")
 
-	DB, err := CreateTestingBD()
+	sessions, err := CreateTestingStore()
 
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	handler := http.HandlerFunc(newHandleAuth(DB))
+	handler := http.HandlerFunc(newHandleAuth(sessions, &dummyMailer{}, &dummyPublisher{}))
 
 	handler.ServeHTTP(recorder, request)
 
@@ -96,6 +115,20 @@ func TestAuthOk(t *testing.T) {
 		t.Fatalf("failed to unmarshall answer from server: %v", err)
 	}
 
+	if !tokens.AccessToken.IsCompact() {
+		t.Fatalf("Access token was not returned in compact serialization: %s", responseBody)
+	}
+
+	verifier := auth.TokenVerifier{Keys: keyring, Issuer: tokenIssuer}
+
+	if _, err := verifier.Parse(tokens.AccessToken.Raw); err != nil {
+		t.Fatalf("failed to verify Access token: %v", err)
+	}
+
+	if tokens.AccessToken.Payload.Subject != guid {
+		t.Fatalf("Access token subject %v is not request GUID %v", tokens.AccessToken.Payload.Subject, guid)
+	}
+
 	refreshToken, err := api.LoadRefreshTokenFromBase64(tokens.RefreshToken)
 
 	if err != nil {
@@ -104,13 +137,13 @@ func TestAuthOk(t *testing.T) {
 
 	session := tokens.AccessToken.Payload.Session
 
-	hash, err := GetSessionHash(DB, session)
+	stored, err := sessions.Get(session)
 
 	if err != nil {
 		t.Fatalf("failed to get hash for session: %v, err: %v", session, err)
 	}
 
-	result, err := refreshToken.Verify(guid, hash)
+	result, err := verifyRefreshToken(refreshToken, guid, stored.TokenHash, stored.TokenHasher)
 
 	if err != nil {
 		t.Fatalf("failed to verify refresh token: %v", err)
@@ -130,12 +163,12 @@ func TestAuthNoGuid(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	DB, err := CreateTestingBD()
+	sessions, err := CreateTestingStore()
 
 	if err != nil {
 		t.Fatal(err)
 	}
-	handler := http.HandlerFunc(newHandleAuth(DB))
+	handler := http.HandlerFunc(newHandleAuth(sessions, &dummyMailer{}, &dummyPublisher{}))
 
 	handler.ServeHTTP(recorder, request)
 
@@ -157,12 +190,12 @@ func TestAuthTooMuchGuid(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	DB, err := CreateTestingBD()
+	sessions, err := CreateTestingStore()
 
 	if err != nil {
 		t.Fatal(err)
 	}
-	handler := http.HandlerFunc(newHandleAuth(DB))
+	handler := http.HandlerFunc(newHandleAuth(sessions, &dummyMailer{}, &dummyPublisher{}))
 
 	handler.ServeHTTP(recorder, request)
 
@@ -181,12 +214,12 @@ func TestAuthInvalidMethod(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	DB, err := CreateTestingBD()
+	sessions, err := CreateTestingStore()
 
 	if err != nil {
 		t.Fatal(err)
 	}
-	handler := http.HandlerFunc(newHandleAuth(DB))
+	handler := http.HandlerFunc(newHandleAuth(sessions, &dummyMailer{}, &dummyPublisher{}))
 
 	handler.ServeHTTP(recorder, request)
 
@@ -196,3 +229,81 @@ func TestAuthInvalidMethod(t *testing.T) {
 		t.Fatalf("Passed wrong request with incorrect method")
 	}
 }
+
+func TestSessionLimit(t *testing.T) {
+	sessions, err := CreateTestingStore()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer sessions.Close()
+
+	t.Cleanup(func() { sessionLimit = store.SessionLimit{Policy: store.LimitReject} })
+
+	mailer := &dummyMailer{}
+	publisher := &dummyPublisher{}
+
+	login := func(guid string) *httptest.ResponseRecorder {
+		request, err := http.NewRequest(http.MethodPost, "/v1/auth", strings.NewReader(""))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		request.Header.Add("Guid", guid)
+		request.RemoteAddr = "127.0.0.1"
+
+		recorder := httptest.NewRecorder()
+
+		http.HandlerFunc(newHandleAuth(sessions, mailer, publisher)).ServeHTTP(recorder, request)
+
+		return recorder
+	}
+
+	sessionLimit = store.SessionLimit{Max: 2, Policy: store.LimitReject}
+
+	first := readTokenPairResponse(t, login("guid"))
+	second := readTokenPairResponse(t, login("guid"))
+
+	if code := login("guid").Code; code != http.StatusForbidden {
+		t.Fatalf("login over session limit must fail with 403, got: %v", code)
+	}
+
+	if code := login("another guid").Code; code != http.StatusOK {
+		t.Fatalf("limit must be counted per user, login failed with code: %v", code)
+	}
+
+	// First session is refreshed, so second one becomes least recently refreshed
+	refresh := http.HandlerFunc(newHandleRefresh(sessions, &dummyMailer{}, &dummyPublisher{}))
+
+	if code := postTokenPair(t, refresh, "guid", first).Code; code != http.StatusOK {
+		t.Fatalf("refresh failed with code: %v", code)
+	}
+
+	sessionLimit.Policy = store.LimitEvict
+
+	readTokenPairResponse(t, login("guid"))
+
+	evicted, err := sessions.Get(second.AccessToken.Payload.Session)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !evicted.Revoked() {
+		t.Fatalf("least recently refreshed session was not evicted")
+	}
+
+	if kept, err := sessions.Get(first.AccessToken.Payload.Session); err != nil || kept.Revoked() {
+		t.Fatalf("recently refreshed session was evicted: %v", err)
+	}
+
+	if len(publisher.events) != 1 || publisher.events[0].Type != events.SessionEvicted || publisher.events[0].Session != evicted.ID {
+		t.Fatalf("eviction was not reported: %#v", publisher.events)
+	}
+
+	if mailer.cnt != 1 {
+		t.Fatalf("user must be warned about evicted session, got %v mails", mailer.cnt)
+	}
+}
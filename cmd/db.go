@@ -1,17 +1,28 @@
 package main
 
 import (
+	"authservice/pkg/store"
 	"database/sql"
 	"fmt"
+	"log"
 	"os"
-	"time"
+	"strconv"
 
 	_ "github.com/lib/pq"
 )
 
-var DB *sql.DB
+// Session stores, selected with SESSION_STORE variable
+const (
+	SessionStorePostgres string = "postgres"
+	SessionStoreSQLite   string = "sqlite"
+	SessionStoreMemory   string = "memory"
+)
+
+// DefaultSQLitePath is SQLite database file used when SQLITE_PATH variable is not set
+const DefaultSQLitePath string = "sessions.db"
 
-func ConnectDB() error {
+// ConnectDB connects to Postgres with CONNECTION_STRING or separate DB_* variables
+func ConnectDB() (*sql.DB, error) {
 	connectionString := os.Getenv("CONNECTION_STRING")
 
 	if connectionString == "" {
@@ -22,7 +33,7 @@ func ConnectDB() error {
 		dbName := os.Getenv("DB_NAME")
 
 		if host == "" || port == "" || user == "" || password == "" || dbName == "" {
-			return fmt.Errorf(`
+			return nil, fmt.Errorf(`
 unable to connect to DB there is no defined connection strngs or separete connection varibales
 connection string variable: CONNECTION_STRING
 `)
@@ -34,54 +45,71 @@ connection string variable: CONNECTION_STRING
 	db, err := sql.Open("postgres", connectionString)
 
 	if err != nil {
-		return fmt.Errorf("failed to connect db: %v", err)
+		return nil, fmt.Errorf("failed to connect db: %v", err)
 	}
 
 	if err := db.Ping(); err != nil {
-		return fmt.Errorf("failed to ping database with error: %v", err)
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database with error: %v", err)
 	}
 
-	DB = db
-
-	return nil
+	return db, nil
 }
 
-type DBProvider interface {
-	QueryRow(query string, args ...any) *sql.Row
-	Exec(query string, args ...any) (sql.Result, error)
-}
+// openSessionStore opens store selected with SESSION_STORE variable, Postgres by default
+func openSessionStore() (store.SessionStore, error) {
+	switch kind := os.Getenv("SESSION_STORE"); kind {
+	case "", SessionStorePostgres:
+		db, err := ConnectDB()
 
-// CheckRefreshTokenHash checks that Refresh token hash is contained in DB
-func GetSessionHash(DB DBProvider, session string) (string, error) {
+		if err != nil {
+			return nil, err
+		}
 
-	row := DB.QueryRow("SELECT session_id, token_hash FROM sessions WHERE session_id = $1", session)
+		return store.NewPostgresStore(db), nil
+	case SessionStoreSQLite:
+		path := os.Getenv("SQLITE_PATH")
 
-	var sessionId, resultHash string
+		if path == "" {
+			path = DefaultSQLitePath
+		}
 
-	if err := row.Scan(&sessionId, &resultHash); err != nil {
-		return "", fmt.Errorf("failed to get refresh token hash for session: %v, got error: %v", session, err)
+		return store.OpenSQLite(path)
+	case SessionStoreMemory:
+		return store.NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown session store: %v, expected %v, %v or %v", kind, SessionStorePostgres, SessionStoreSQLite, SessionStoreMemory)
 	}
-
-	return resultHash, nil
 }
 
-// AddRefreshTokenHash stores Refresh token hash to DB
-func AddSession(DB DBProvider, hash string, GUID string, session string, expires time.Time) error {
-	_, err := DB.Exec("INSERT INTO sessions (session_id, GUID, token_hash, expires_at) VALUES ($1, $2, $3, $4)", session, GUID, hash, expires)
+// migrateOnStart applies pending migrations of store unless AUTO_MIGRATE variable is false
+func migrateOnStart(sessions store.SessionStore) error {
+	if auto := os.Getenv("AUTO_MIGRATE"); auto != "" {
+		enabled, err := strconv.ParseBool(auto)
 
-	if err != nil {
-		return fmt.Errorf("failed to add session: %v, got error: %v", session, err)
+		if err != nil {
+			return fmt.Errorf("invalid AUTO_MIGRATE value: %v, expected true or false", auto)
+		}
+
+		if !enabled {
+			return nil
+		}
 	}
 
-	return nil
-}
+	migrator, ok := sessions.(store.Migrator)
 
-// AddRefreshTokenHash stores Refresh token hash to DB
-func UpdateSession(DB DBProvider, hash string, session string, expires time.Time) error {
-	_, err := DB.Exec("UPDATE sessions SET token_hash = $1, expires_at = $2 WHERE session_id = $3", hash, expires, session)
+	if !ok {
+		return nil
+	}
+
+	applied, err := migrator.MigrateUp()
 
 	if err != nil {
-		return fmt.Errorf("failed to update session: %v, got error: %v", session, err)
+		return fmt.Errorf("failed to migrate session store: %w", err)
+	}
+
+	if applied > 0 {
+		log.Default().Printf("applied %v schema migrations\n", applied)
 	}
 
 	return nil
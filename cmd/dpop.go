@@ -0,0 +1,80 @@
+package main
+
+import (
+	"authservice/pkg/auth"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+)
+
+// dpopNonces makes server nonces required in DPoP proofs, enabled with DPOP_NONCE_SECRET variable
+var dpopNonces *auth.DPoPNonces
+
+// dpopReplay remembers jti of accepted DPoP proofs
+var dpopReplay *auth.DPoPReplayCache = auth.NewDPoPReplayCache()
+
+// dpopRequired rejects /v1/auth requests without DPoP proof, enabled with DPOP_REQUIRED variable
+var dpopRequired bool
+
+// publicURL is scheme and host of service as seen by clients, configured with PUBLIC_URL variable
+// It is compared with "htu" claim of DPoP proofs, request host is used when empty
+var publicURL string
+
+// DPoPErrorResponse is error answer of token endpoints as defined by RFC 9449
+type DPoPErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// dpopProofValidator returns validator of DPoP proofs sent to this service
+func dpopProofValidator() auth.DPoPValidator {
+	return auth.DPoPValidator{
+		Time:      timeValidator(),
+		Nonces:    dpopNonces,
+		Replay:    dpopReplay,
+		PublicURL: publicURL,
+	}
+}
+
+// readDPoPProof validates DPoP proof of token endpoint request, jkt is thumbprint of key session is bound to
+// On failure it writes response and returns false
+func readDPoPProof(w http.ResponseWriter, r *http.Request, jkt string) (auth.DPoPProof, bool) {
+	validator := dpopProofValidator()
+
+	proof, err := validator.Validate(r, "", jkt)
+
+	if err == nil {
+		return proof, true
+	}
+
+	response := DPoPErrorResponse{
+		Error:            "invalid_dpop_proof",
+		ErrorDescription: err.Error(),
+	}
+
+	if errors.Is(err, auth.ErrUseDPoPNonce) {
+		response = DPoPErrorResponse{
+			Error:            "use_dpop_nonce",
+			ErrorDescription: "Authorization server requires nonce in DPoP proof",
+		}
+		w.Header().Set(auth.DPoPNonceHeader, validator.Nonce())
+	}
+
+	log.Default().Printf("rejected DPoP proof: %v\n", err)
+
+	answerJson, err := json.Marshal(response)
+
+	if err != nil {
+		log.Default().Println("DPoP error json marshalling error: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return auth.DPoPProof{}, false
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusBadRequest)
+	w.Write(answerJson)
+
+	return auth.DPoPProof{}, false
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	api "authservice/pkg/api"
+	"authservice/pkg/auth"
+	"authservice/pkg/store"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+const testPublicURL string = "https://auth.example.com"
+
+func newTestDPoPKey(t *testing.T) (auth.Signer, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := auth.NewSigner(key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jkt, err := auth.KeyID(signer)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signer, jkt
+}
+
+// postWithDPoP sends request to handler on behalf of guid with DPoP proof signed by signer unless it is nil
+func postWithDPoP(t *testing.T, handler http.HandlerFunc, path string, guid string, tokens *api.RefreshAccessTokenPair, signer auth.Signer, nonce string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var requestBody []byte
+
+	if tokens != nil {
+		var err error
+
+		if requestBody, err = json.Marshal(tokens); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	request, err := http.NewRequest(http.MethodPost, path, bytes.NewBuffer(requestBody))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request.Header.Set("Guid", guid)
+	request.RemoteAddr = "127.0.0.1"
+
+	if signer != nil {
+		proof, err := auth.NewDPoPProof(signer, http.MethodPost, testPublicURL+path, "", nonce, clock.Now())
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		request.Header.Set(auth.DPoPHeader, proof)
+	}
+
+	recorder := httptest.NewRecorder()
+
+	handler.ServeHTTP(recorder, request)
+
+	return recorder
+}
+
+func readDPoPError(t *testing.T, recorder *httptest.ResponseRecorder) DPoPErrorResponse {
+	t.Helper()
+
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expected DPoP error with code 400, got: %v %v", recorder.Code, recorder.Body.String())
+	}
+
+	var response DPoPErrorResponse
+
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("failed to unmarshall DPoP error: %v", err)
+	}
+
+	return response
+}
+
+func setupDPoPTest(t *testing.T) *store.SQLStore {
+	t.Helper()
+
+	publicURL = testPublicURL
+	dpopReplay = auth.NewDPoPReplayCache()
+
+	t.Cleanup(func() {
+		publicURL = ""
+		dpopNonces = nil
+		dpopRequired = false
+	})
+
+	sessions, err := CreateTestingStore()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { sessions.Close() })
+
+	return sessions
+}
+
+func TestDPoPBinding(t *testing.T) {
+	sessions := setupDPoPTest(t)
+
+	authHandler := http.HandlerFunc(newHandleAuth(sessions, &dummyMailer{}, &dummyPublisher{}))
+	refresh := http.HandlerFunc(newHandleRefresh(sessions, &dummyMailer{}, &dummyPublisher{}))
+
+	signer, jkt := newTestDPoPKey(t)
+	another, _ := newTestDPoPKey(t)
+
+	first := readTokenPairResponse(t, postWithDPoP(t, authHandler, "/v1/auth", "guid", nil, signer, ""))
+
+	if first.TokenType != api.TokenTypeDPoP {
+		t.Fatalf("unexpected token type: %v", first.TokenType)
+	}
+
+	if first.AccessToken.Payload.Confirmation == nil || first.AccessToken.Payload.Confirmation.JKT != jkt {
+		t.Fatalf("Access token is not bound to proof key: %#v", first.AccessToken.Payload.Confirmation)
+	}
+
+	stored, err := sessions.Get(first.AccessToken.Payload.Session)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stored.DPoPJKT != jkt {
+		t.Fatalf("session is not bound to proof key: %v", stored.DPoPJKT)
+	}
+
+	code, response := introspect(t, sessions, url.Values{"token": {first.AccessToken.Raw}})
+
+	if code != http.StatusOK || response.TokenType != api.TokenTypeDPoP || response.Cnf == nil || response.Cnf.JKT != jkt {
+		t.Fatalf("unexpected introspection of bound token: %v %#v", code, response)
+	}
+
+	if response := readDPoPError(t, postWithDPoP(t, refresh, "/v1/refresh", "guid", &first, nil, "")); response.Error != "invalid_dpop_proof" {
+		t.Fatalf("refresh without proof got unexpected error: %v", response.Error)
+	}
+
+	if response := readDPoPError(t, postWithDPoP(t, refresh, "/v1/refresh", "guid", &first, another, "")); response.Error != "invalid_dpop_proof" {
+		t.Fatalf("refresh with proof of another key got unexpected error: %v", response.Error)
+	}
+
+	second := readTokenPairResponse(t, postWithDPoP(t, refresh, "/v1/refresh", "guid", &first, signer, ""))
+
+	if second.TokenType != api.TokenTypeDPoP || second.AccessToken.Payload.Confirmation == nil || second.AccessToken.Payload.Confirmation.JKT != jkt {
+		t.Fatalf("refreshed Access token is not bound to proof key: %#v", second)
+	}
+
+	protected := accessTokenVerifier().Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	request := httptest.NewRequest(http.MethodGet, testPublicURL+"/api", nil)
+	request.Header.Set("Authorization", "Bearer "+second.AccessToken.Raw)
+
+	recorder := httptest.NewRecorder()
+	protected.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("bound Access token was accepted as bearer token: %v", recorder.Code)
+	}
+
+	proof, err := auth.NewDPoPProof(signer, http.MethodGet, testPublicURL+"/api", second.AccessToken.Raw, "", clock.Now())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request.Header.Set("Authorization", "DPoP "+second.AccessToken.Raw)
+	request.Header.Set(auth.DPoPHeader, proof)
+
+	recorder = httptest.NewRecorder()
+	protected.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("bound Access token with proof was rejected: %v %v", recorder.Code, recorder.Header().Get("WWW-Authenticate"))
+	}
+
+	logouts := map[string]http.HandlerFunc{
+		"/v1/logout":     http.HandlerFunc(newHandleLogout(sessions)),
+		"/v1/logout/all": http.HandlerFunc(newHandleLogoutAll(sessions)),
+	}
+
+	for path, logout := range logouts {
+		if response := readDPoPError(t, postWithDPoP(t, logout, path, "guid", &second, nil, "")); response.Error != "invalid_dpop_proof" {
+			t.Fatalf("%v without proof got unexpected error: %v", path, response.Error)
+		}
+
+		if response := readDPoPError(t, postWithDPoP(t, logout, path, "guid", &second, another, "")); response.Error != "invalid_dpop_proof" {
+			t.Fatalf("%v with proof of another key got unexpected error: %v", path, response.Error)
+		}
+	}
+
+	if code := postWithDPoP(t, logouts["/v1/logout"], "/v1/logout", "guid", &second, signer, "").Code; code != http.StatusNoContent {
+		t.Fatalf("logout with proof failed: %v", code)
+	}
+
+	bearer := authenticate(t, sessions, "guid")
+
+	if bearer.TokenType != api.TokenTypeBearer || bearer.AccessToken.Payload.Confirmation != nil {
+		t.Fatalf("Access token without proof is bound: %#v", bearer)
+	}
+
+	if code := postTokenPair(t, refresh, "guid", bearer).Code; code != http.StatusOK {
+		t.Fatalf("refresh of bearer session failed: %v", code)
+	}
+}
+
+func TestDPoPNonce(t *testing.T) {
+	sessions := setupDPoPTest(t)
+
+	dpopNonces = auth.NewDPoPNonces([]byte("nonce secret of test"), time.Minute)
+	dpopRequired = true
+
+	authHandler := http.HandlerFunc(newHandleAuth(sessions, &dummyMailer{}, &dummyPublisher{}))
+
+	signer, _ := newTestDPoPKey(t)
+
+	if response := readDPoPError(t, postWithDPoP(t, authHandler, "/v1/auth", "guid", nil, nil, "")); response.Error != "invalid_dpop_proof" {
+		t.Fatalf("auth without required proof got unexpected error: %v", response.Error)
+	}
+
+	recorder := postWithDPoP(t, authHandler, "/v1/auth", "guid", nil, signer, "")
+
+	if response := readDPoPError(t, recorder); response.Error != "use_dpop_nonce" {
+		t.Fatalf("auth without nonce got unexpected error: %v", response.Error)
+	}
+
+	nonce := recorder.Header().Get(auth.DPoPNonceHeader)
+
+	if nonce == "" {
+		t.Fatalf("server nonce was not returned")
+	}
+
+	tokens := readTokenPairResponse(t, postWithDPoP(t, authHandler, "/v1/auth", "guid", nil, signer, nonce))
+
+	if tokens.TokenType != api.TokenTypeDPoP {
+		t.Fatalf("unexpected token type: %v", tokens.TokenType)
+	}
+}
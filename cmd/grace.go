@@ -0,0 +1,84 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// refreshGracePeriod is time after rotation during which previous Refresh token returns already issued pair, zero disables it
+var refreshGracePeriod time.Duration = 0
+
+// issuedPairs keeps responses of refreshes for retries during refreshGracePeriod
+var issuedPairs *issuedPairCache = newIssuedPairCache()
+
+// issuedPairKey is session and generation of Refresh token presented to refresh
+type issuedPairKey struct {
+	session    string
+	generation int
+}
+
+type issuedPair struct {
+	response  []byte
+	expiresAt time.Time
+}
+
+type issuedPairEntry struct {
+	key       issuedPairKey
+	expiresAt time.Time
+}
+
+// issuedPairCache keeps refresh responses in memory until grace period ends
+// It is safe for concurrent use
+type issuedPairCache struct {
+	mu    sync.Mutex
+	pairs map[issuedPairKey]issuedPair
+	// queue holds keys in order they were stored, expired pairs are dropped from its head
+	queue []issuedPairEntry
+}
+
+func newIssuedPairCache() *issuedPairCache {
+	return &issuedPairCache{
+		pairs: map[issuedPairKey]issuedPair{},
+	}
+}
+
+// Store keeps response of refresh with Refresh token of session generation until expiresAt
+func (c *issuedPairCache) Store(session string, generation int, response []byte, expiresAt time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := clock.Now()
+
+	// Pairs are kept for same grace period, so they expire in order they were stored
+	for len(c.queue) > 0 && now.After(c.queue[0].expiresAt) {
+		entry := c.queue[0]
+		c.queue = c.queue[1:]
+
+		if pair, ok := c.pairs[entry.key]; ok && pair.expiresAt.Equal(entry.expiresAt) {
+			delete(c.pairs, entry.key)
+		}
+	}
+
+	key := issuedPairKey{session, generation}
+
+	c.pairs[key] = issuedPair{
+		response:  response,
+		expiresAt: expiresAt,
+	}
+
+	c.queue = append(c.queue, issuedPairEntry{key: key, expiresAt: expiresAt})
+}
+
+// Load returns response of refresh with Refresh token of session generation if it has not expired
+func (c *issuedPairCache) Load(session string, generation int, now time.Time) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pair, ok := c.pairs[issuedPairKey{session, generation}]
+
+	if !ok || now.After(pair.expiresAt) {
+		return nil, false
+	}
+
+	return pair.response, true
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIssuedPairCache(t *testing.T) {
+	cache := newIssuedPairCache()
+	now := testNow
+	fixClock(t, &now)
+
+	// Pairs are stored in order they expire
+	cache.Store("expired", 0, []byte("expired pair"), now.Add(-time.Minute))
+	cache.Store("session", 0, []byte("pair"), now.Add(time.Minute))
+
+	if response, ok := cache.Load("session", 0, now); !ok || string(response) != "pair" {
+		t.Fatalf("stored pair was not loaded: %q", response)
+	}
+
+	if _, ok := cache.Load("session", 1, now); ok {
+		t.Fatalf("pair of another generation was loaded")
+	}
+
+	if _, ok := cache.Load("session", 0, now.Add(2*time.Minute)); ok {
+		t.Fatalf("pair was loaded after grace period")
+	}
+
+	if _, ok := cache.Load("expired", 0, now); ok {
+		t.Fatalf("expired pair was loaded")
+	}
+
+	cache.Store("another", 0, []byte("another pair"), now.Add(time.Minute))
+
+	if _, ok := cache.pairs[issuedPairKey{"expired", 0}]; ok {
+		t.Fatalf("expired pair was not purged")
+	}
+
+	if len(cache.queue) != 2 {
+		t.Fatalf("expired pair was not dropped from queue: %v entries", len(cache.queue))
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	api "authservice/pkg/api"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// tokenHasher hashes Refresh tokens of new and rotated sessions
+var tokenHasher api.TokenHasher = api.BcryptHasher{}
+
+// tokenHashers verify stored hashes by hasher ID, so sessions hashed before hasher was changed keep working
+var tokenHashers api.TokenHashers = api.NewTokenHashers(api.BcryptHasher{})
+
+// loadTokenHashers selects hasher with TOKEN_HASHER variable, HMAC pepper is read from TOKEN_HASH_PEPPER_FILE or TOKEN_HASH_PEPPER
+// HMAC hasher is kept for verification whenever pepper is configured, so switching back to bcrypt does not break sessions
+func loadTokenHashers() (api.TokenHasher, api.TokenHashers, error) {
+	hasherID := os.Getenv("TOKEN_HASHER")
+
+	if hasherID == "" {
+		hasherID = api.BcryptHasherID
+	}
+
+	pepper := os.Getenv("TOKEN_HASH_PEPPER")
+
+	if pepperFile := os.Getenv("TOKEN_HASH_PEPPER_FILE"); pepperFile != "" {
+		data, err := os.ReadFile(pepperFile)
+
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read token hash pepper file: %w", err)
+		}
+
+		pepper = strings.TrimSpace(string(data))
+	}
+
+	hashers := api.NewTokenHashers(api.BcryptHasher{})
+
+	if pepper != "" {
+		hmacHasher, err := api.NewHMACHasher([]byte(pepper))
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		hashers[hmacHasher.ID()] = hmacHasher
+	}
+
+	hasher, err := hashers.Get(hasherID)
+
+	if err != nil {
+		if hasherID == api.HMACSHA256HasherID {
+			return nil, nil, fmt.Errorf("TOKEN_HASH_PEPPER or TOKEN_HASH_PEPPER_FILE is required by %v token hasher", hasherID)
+		}
+		return nil, nil, err
+	}
+
+	return hasher, hashers, nil
+}
+
+// hashRefreshToken computes hash of Refresh token with tokenHasher, returns hash and ID of hasher to store with it
+func hashRefreshToken(refreshToken api.SessionRefreshToken, GUID string) (hash string, hasherID string, err error) {
+	hash, err = api.HashToken(tokenHasher, refreshToken, GUID)
+	return hash, tokenHasher.ID(), err
+}
+
+// verifyRefreshToken checks Refresh token against stored hash with hasher which computed it
+func verifyRefreshToken(refreshToken api.SessionRefreshToken, GUID string, hash string, hasherID string) (bool, error) {
+	hasher, err := tokenHashers.Get(hasherID)
+
+	if err != nil {
+		return false, err
+	}
+
+	return api.VerifyToken(hasher, refreshToken, GUID, hash)
+}
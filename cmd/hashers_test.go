@@ -0,0 +1,107 @@
+package main
+
+import (
+	api "authservice/pkg/api"
+	"net/http"
+	"testing"
+)
+
+func TestLoadTokenHashers(t *testing.T) {
+	t.Setenv("TOKEN_HASH_PEPPER_FILE", "")
+	t.Setenv("TOKEN_HASH_PEPPER", "")
+	t.Setenv("TOKEN_HASHER", api.HMACSHA256HasherID)
+
+	if _, _, err := loadTokenHashers(); err == nil {
+		t.Fatalf("HMAC token hasher was loaded without pepper")
+	}
+
+	t.Setenv("TOKEN_HASH_PEPPER", "testing pepper which is long enough")
+
+	hasher, hashers, err := loadTokenHashers()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hasher.ID() != api.HMACSHA256HasherID {
+		t.Fatalf("unexpected token hasher: %v", hasher.ID())
+	}
+
+	if _, err := hashers.Get(api.BcryptHasherID); err != nil {
+		t.Fatalf("bcrypt hasher is not kept for verification: %v", err)
+	}
+
+	t.Setenv("TOKEN_HASHER", "")
+
+	hasher, hashers, err = loadTokenHashers()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hasher.ID() != api.BcryptHasherID {
+		t.Fatalf("bcrypt must be default token hasher, got: %v", hasher.ID())
+	}
+
+	if _, err := hashers.Get(api.HMACSHA256HasherID); err != nil {
+		t.Fatalf("HMAC hasher is not kept for verification when pepper is configured: %v", err)
+	}
+}
+
+func TestRefreshTokenHasherMigration(t *testing.T) {
+	sessions, err := CreateTestingStore()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer sessions.Close()
+
+	refresh := http.HandlerFunc(newHandleRefresh(sessions, &dummyMailer{}, &dummyPublisher{}))
+
+	// Session hashed with bcrypt before HMAC hasher was enabled
+	first := authenticate(t, sessions, "guid")
+	session := first.AccessToken.Payload.Session
+
+	hmacHasher, err := api.NewHMACHasher([]byte("testing pepper which is long enough"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(hasher api.TokenHasher, hashers api.TokenHashers) {
+		tokenHasher, tokenHashers = hasher, hashers
+	}(tokenHasher, tokenHashers)
+
+	tokenHasher = hmacHasher
+	tokenHashers = api.NewTokenHashers(api.BcryptHasher{}, hmacHasher)
+
+	second := readTokenPairResponse(t, postTokenPair(t, refresh, "guid", first))
+
+	stored, err := sessions.Get(session)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stored.TokenHasher != api.HMACSHA256HasherID {
+		t.Fatalf("rotated session was hashed with %v", stored.TokenHasher)
+	}
+
+	readTokenPairResponse(t, postTokenPair(t, refresh, "guid", second))
+
+	// Reuse detection verifies rotated bcrypt hash
+	if code := postTokenPair(t, refresh, "guid", first).Code; code != http.StatusUnauthorized {
+		t.Fatalf("reused Refresh token must fail with 401, got: %v", code)
+	}
+
+	stored, err = sessions.Get(session)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !stored.Revoked() {
+		t.Fatalf("session was not revoked after reuse of bcrypt hashed token")
+	}
+}
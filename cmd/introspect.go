@@ -0,0 +1,224 @@
+package main
+
+import (
+	api "authservice/pkg/api"
+	"authservice/pkg/auth"
+	"authservice/pkg/store"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+)
+
+// Token type hints defined by RFC 7662
+const (
+	TokenTypeHintAccessToken  string = "access_token"
+	TokenTypeHintRefreshToken string = "refresh_token"
+)
+
+// IntrospectionResponse is answer of /v1/introspect as defined by RFC 7662
+// Inactive tokens are described only with Active field
+type IntrospectionResponse struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Nbf       int64  `json:"nbf,omitempty"`
+	Sub       string `json:"sub,omitempty"`
+	Aud       string `json:"aud,omitempty"`
+	Iss       string `json:"iss,omitempty"`
+	Jti       string `json:"jti,omitempty"`
+	Session   string `json:"session,omitempty"`
+	// Cnf is key DPoP bound token is confirmed with
+	Cnf *api.Confirmation `json:"cnf,omitempty"`
+}
+
+// activeSession loads session and checks that it is neither expired nor revoked
+func activeSession(sessions store.SessionStore, session string) (store.Session, bool, error) {
+	result, err := sessions.Get(session)
+
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return store.Session{}, false, nil
+		}
+		return store.Session{}, false, err
+	}
+
+	if !result.Active(clock.Now()) {
+		return store.Session{}, false, nil
+	}
+
+	return result, true, nil
+}
+
+// introspectAccessToken checks Access token in compact or legacy JSON form and its session
+func introspectAccessToken(sessions store.SessionStore, raw string) (IntrospectionResponse, error) {
+	verifier := accessTokenVerifier()
+
+	token, err := verifier.Parse(raw)
+
+	if err != nil {
+		var legacy api.AccessToken
+
+		if json.Unmarshal([]byte(raw), &legacy) != nil || verifier.Verify(legacy) != nil {
+			return IntrospectionResponse{}, nil
+		}
+
+		token = legacy
+	}
+
+	session, ok, err := activeSession(sessions, token.Payload.Session)
+
+	if err != nil || !ok {
+		return IntrospectionResponse{}, err
+	}
+
+	if session.GUID != token.Payload.Subject {
+		return IntrospectionResponse{}, nil
+	}
+
+	tokenType := api.TokenTypeBearer
+
+	if token.Payload.Confirmation != nil && token.Payload.Confirmation.JKT != "" {
+		tokenType = api.TokenTypeDPoP
+	}
+
+	return IntrospectionResponse{
+		Active:    true,
+		Scope:     token.Payload.Scope,
+		TokenType: tokenType,
+		Exp:       token.Header.Exp.Unix(),
+		Iat:       token.Payload.IssuedAt,
+		Nbf:       token.Payload.NotBefore,
+		Sub:       token.Payload.Subject,
+		Aud:       token.Payload.Audience,
+		Iss:       token.Payload.Issuer,
+		Jti:       token.Payload.ID,
+		Session:   token.Payload.Session,
+		Cnf:       token.Payload.Confirmation,
+	}, nil
+}
+
+// introspectRefreshToken checks Refresh token against hash stored in its session
+func introspectRefreshToken(sessions store.SessionStore, raw string) (IntrospectionResponse, error) {
+	refreshToken, err := api.LoadRefreshToken(raw)
+
+	if err != nil {
+		return IntrospectionResponse{}, nil
+	}
+
+	id, _ := refreshToken.Lookup()
+
+	if id == "" {
+		return IntrospectionResponse{}, nil
+	}
+
+	session, ok, err := activeSession(sessions, id)
+
+	if err != nil || !ok {
+		return IntrospectionResponse{}, err
+	}
+
+	verified, err := verifyRefreshToken(refreshToken, session.GUID, session.TokenHash, session.TokenHasher)
+
+	if err != nil || !verified {
+		return IntrospectionResponse{}, nil
+	}
+
+	// token_type of RFC 7662 is type of Access token usage (Bearer or DPoP), so it is not reported for Refresh token
+	return IntrospectionResponse{
+		Active:  true,
+		Scope:   tokenScope,
+		Exp:     session.ExpiresAt.Unix(),
+		Sub:     session.GUID,
+		Iss:     tokenIssuer,
+		Session: session.ID,
+		Cnf:     sessionConfirmation(session),
+	}, nil
+}
+
+// authorizeIntrospection checks bearer secret of resource server
+// Introspection is refused while introspectionSecret is not configured, RFC 7662 requires callers to be authenticated
+func authorizeIntrospection(r *http.Request) bool {
+	if introspectionSecret == "" {
+		return false
+	}
+
+	token, err := auth.BearerToken(r)
+
+	if err != nil {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(token), []byte(introspectionSecret)) == 1
+}
+
+func newHandleIntrospect(sessions store.SessionStore) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			msg := "must use POST"
+			w.WriteHeader(http.StatusBadRequest)
+			log.Default().Println(msg)
+			w.Write([]byte(msg))
+			return
+		}
+
+		if !authorizeIntrospection(r) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			log.Default().Printf("failed to parse introspection request: %v\n", err)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("incorrect introspection request"))
+			return
+		}
+
+		token := r.PostForm.Get("token")
+
+		if token == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("there is no token in request"))
+			return
+		}
+
+		var response IntrospectionResponse
+		var err error
+
+		if r.PostForm.Get("token_type_hint") == TokenTypeHintRefreshToken {
+			response, err = introspectRefreshToken(sessions, token)
+
+			if err == nil && !response.Active {
+				response, err = introspectAccessToken(sessions, token)
+			}
+		} else {
+			response, err = introspectAccessToken(sessions, token)
+
+			if err == nil && !response.Active {
+				response, err = introspectRefreshToken(sessions, token)
+			}
+		}
+
+		if err != nil {
+			log.Default().Printf("error when trying to introspect token: %v\n", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		answerJson, err := json.Marshal(response)
+
+		if err != nil {
+			log.Default().Println("introspection answer json marshalling error: ", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(answerJson)
+	}
+}
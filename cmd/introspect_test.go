@@ -0,0 +1,179 @@
+package main
+
+import (
+	api "authservice/pkg/api"
+	"authservice/pkg/store"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// authenticate performs /v1/auth request and returns issued token pair
+func authenticate(t *testing.T, sessions store.SessionStore, guid string) api.RefreshAccessTokenPair {
+	t.Helper()
+
+	request, err := http.NewRequest(http.MethodPost, "/v1/auth", strings.NewReader(""))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request.Header.Add("Guid", guid)
+	request.RemoteAddr = "127.0.0.1"
+
+	recorder := httptest.NewRecorder()
+
+	http.HandlerFunc(newHandleAuth(sessions, &dummyMailer{}, &dummyPublisher{})).ServeHTTP(recorder, request)
+
+	response := recorder.Result()
+
+	if response.StatusCode != 200 {
+		t.Fatalf("Auth request failed with code: %v", response.Status)
+	}
+
+	responseBody, err := io.ReadAll(response.Body)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tokens api.RefreshAccessTokenPair
+
+	if err = json.Unmarshal(responseBody, &tokens); err != nil {
+		t.Fatalf("failed to unmarshall answer from server: %v", err)
+	}
+
+	return tokens
+}
+
+// introspect sends introspection request on behalf of resource server which knows introspectionSecret
+func introspect(t *testing.T, sessions store.SessionStore, form url.Values) (int, IntrospectionResponse) {
+	t.Helper()
+
+	return introspectWith(t, sessions, form, "Bearer "+introspectionSecret)
+}
+
+// introspectWith sends introspection request with Authorization header unless it is empty
+func introspectWith(t *testing.T, sessions store.SessionStore, form url.Values, authorization string) (int, IntrospectionResponse) {
+	t.Helper()
+
+	request, err := http.NewRequest(http.MethodPost, "/v1/introspect", strings.NewReader(form.Encode()))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	if authorization != "" {
+		request.Header.Set("Authorization", authorization)
+	}
+
+	recorder := httptest.NewRecorder()
+
+	http.HandlerFunc(newHandleIntrospect(sessions)).ServeHTTP(recorder, request)
+
+	var result IntrospectionResponse
+
+	if recorder.Code == http.StatusOK {
+		if err := json.Unmarshal(recorder.Body.Bytes(), &result); err != nil {
+			t.Fatalf("failed to unmarshall answer from server: %v", err)
+		}
+	}
+
+	return recorder.Code, result
+}
+
+func TestIntrospect(t *testing.T) {
+	sessions, err := CreateTestingStore()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer sessions.Close()
+
+	tokens := authenticate(t, sessions, "guid")
+
+	_, result := introspect(t, sessions, url.Values{"token": {tokens.AccessToken.Raw}})
+
+	if !result.Active || result.Sub != "guid" || result.Session != tokens.AccessToken.Payload.Session || result.Exp == 0 {
+		t.Fatalf("unexpected Access token introspection: %#v", result)
+	}
+
+	_, result = introspect(t, sessions, url.Values{"token": {tokens.RefreshToken}, "token_type_hint": {TokenTypeHintRefreshToken}})
+
+	if !result.Active || result.Sub != "guid" || result.Session != tokens.AccessToken.Payload.Session || result.TokenType != "" {
+		t.Fatalf("unexpected Refresh token introspection: %#v", result)
+	}
+
+	_, result = introspect(t, sessions, url.Values{"token": {"garbage"}})
+
+	if result.Active {
+		t.Fatalf("garbage token is active")
+	}
+
+	if code, _ := introspect(t, sessions, url.Values{}); code != http.StatusBadRequest {
+		t.Fatalf("request without token must fail, got: %v", code)
+	}
+
+	if _, err = sessions.DB().Exec("DELETE FROM sessions"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, result = introspect(t, sessions, url.Values{"token": {tokens.AccessToken.Raw}})
+
+	if result.Active {
+		t.Fatalf("Access token of removed session is active")
+	}
+
+	_, result = introspect(t, sessions, url.Values{"token": {tokens.RefreshToken}})
+
+	if result.Active {
+		t.Fatalf("Refresh token of removed session is active")
+	}
+}
+
+func TestIntrospectSecret(t *testing.T) {
+	sessions, err := CreateTestingStore()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer sessions.Close()
+
+	tokens := authenticate(t, sessions, "guid")
+
+	form := url.Values{"token": {tokens.AccessToken.Raw}}
+
+	if code, _ := introspectWith(t, sessions, form, ""); code != http.StatusUnauthorized {
+		t.Fatalf("introspection without secret must fail, got: %v", code)
+	}
+
+	if code, _ := introspectWith(t, sessions, form, "Bearer wrong secret"); code != http.StatusUnauthorized {
+		t.Fatalf("introspection with wrong secret must fail, got: %v", code)
+	}
+
+	code, result := introspectWith(t, sessions, form, "Bearer "+introspectionSecret)
+
+	if code != http.StatusOK || !result.Active {
+		t.Fatalf("introspection with secret failed: %v %#v", code, result)
+	}
+
+	testingSecret := introspectionSecret
+	introspectionSecret = ""
+
+	defer func() { introspectionSecret = testingSecret }()
+
+	// Introspection is closed until secret is configured
+	for _, authorization := range []string{"", "Bearer "} {
+		if code, _ := introspectWith(t, sessions, form, authorization); code != http.StatusUnauthorized {
+			t.Fatalf("introspection without configured secret must fail, got: %v", code)
+		}
+	}
+}
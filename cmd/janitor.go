@@ -0,0 +1,139 @@
+package main
+
+import (
+	"authservice/pkg/auth"
+	"authservice/pkg/store"
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Janitor defaults, changed with JANITOR_INTERVAL, JANITOR_BATCH_SIZE and REVOKED_SESSION_RETENTION variables
+const (
+	DefaultJanitorInterval         time.Duration = time.Hour
+	DefaultJanitorBatchSize        int           = 1000
+	DefaultRevokedSessionRetention time.Duration = time.Hour * 24 * 7
+)
+
+// Janitor metrics are published with expvar on /debug/vars
+var (
+	janitorPurgedSessions *expvar.Int = expvar.NewInt("janitor_purged_sessions")
+	janitorRuns           *expvar.Int = expvar.NewInt("janitor_runs")
+	janitorErrors         *expvar.Int = expvar.NewInt("janitor_errors")
+	janitorLastRun        *expvar.Int = expvar.NewInt("janitor_last_run_unix")
+)
+
+// Janitor periodically deletes sessions past expiry and sessions revoked longer than Retention
+// Revoked sessions are kept for a while so reuse of their Refresh tokens is still answered as revoked
+type Janitor struct {
+	Sessions store.SessionStore
+	Clock    auth.Clock
+	// Interval is pause between purges
+	Interval time.Duration
+	// BatchSize limits sessions deleted in one statement, so purge does not hold long locks
+	BatchSize int
+	// Retention is time revoked sessions are kept after revocation
+	Retention time.Duration
+}
+
+// loadJanitor configures janitor of sessions, nil means it is disabled with JANITOR_INTERVAL=0
+func loadJanitor(sessions store.SessionStore) (*Janitor, error) {
+	janitor := &Janitor{
+		Sessions:  sessions,
+		Clock:     clock,
+		Interval:  DefaultJanitorInterval,
+		BatchSize: DefaultJanitorBatchSize,
+		Retention: DefaultRevokedSessionRetention,
+	}
+
+	if interval := os.Getenv("JANITOR_INTERVAL"); interval != "" {
+		duration, err := time.ParseDuration(interval)
+
+		if err != nil || duration < 0 {
+			return nil, fmt.Errorf("invalid janitor interval: %v, expected duration such as 1h", interval)
+		}
+
+		if duration == 0 {
+			return nil, nil
+		}
+
+		janitor.Interval = duration
+	}
+
+	if batchSize := os.Getenv("JANITOR_BATCH_SIZE"); batchSize != "" {
+		value, err := strconv.Atoi(batchSize)
+
+		if err != nil || value < 1 {
+			return nil, fmt.Errorf("invalid janitor batch size: %v, expected positive number", batchSize)
+		}
+
+		janitor.BatchSize = value
+	}
+
+	if retention := os.Getenv("REVOKED_SESSION_RETENTION"); retention != "" {
+		duration, err := time.ParseDuration(retention)
+
+		if err != nil || duration < 0 {
+			return nil, fmt.Errorf("invalid revoked session retention: %v, expected duration such as 168h", retention)
+		}
+
+		janitor.Retention = duration
+	}
+
+	return janitor, nil
+}
+
+// Purge deletes expired and long revoked sessions batch by batch until none is left or ctx is done
+// Returns number of deleted sessions
+func (j *Janitor) Purge(ctx context.Context) (int64, error) {
+	now := j.Clock.Now()
+
+	var total int64
+
+	for ctx.Err() == nil {
+		purged, err := j.Sessions.Purge(now, now.Add(-j.Retention), j.BatchSize)
+
+		total += purged
+		janitorPurgedSessions.Add(purged)
+
+		if err != nil {
+			return total, err
+		}
+
+		if purged < int64(j.BatchSize) {
+			break
+		}
+	}
+
+	return total, nil
+}
+
+// Run purges sessions every Interval until ctx is done
+func (j *Janitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.Interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := j.Purge(ctx)
+
+		janitorRuns.Add(1)
+		janitorLastRun.Set(j.Clock.Now().Unix())
+
+		if err != nil {
+			janitorErrors.Add(1)
+			log.Default().Printf("failed to purge sessions: %v\n", err)
+		} else if purged > 0 {
+			log.Default().Printf("purged %v expired and revoked sessions\n", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"authservice/pkg/auth"
+	"authservice/pkg/store"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadJanitor(t *testing.T) {
+	tests := []struct {
+		Name      string
+		Interval  string
+		BatchSize string
+		Retention string
+		MustFail  bool
+		Disabled  bool
+		Expected  Janitor
+	}{
+		{Name: "Defaults", Expected: Janitor{Interval: DefaultJanitorInterval, BatchSize: DefaultJanitorBatchSize, Retention: DefaultRevokedSessionRetention}},
+		{Name: "Configured", Interval: "10m", BatchSize: "50", Retention: "24h", Expected: Janitor{Interval: 10 * time.Minute, BatchSize: 50, Retention: 24 * time.Hour}},
+		{Name: "Disabled", Interval: "0", Disabled: true},
+		{Name: "Invalid interval", Interval: "often", MustFail: true},
+		{Name: "Negative interval", Interval: "-1h", MustFail: true},
+		{Name: "Invalid batch size", BatchSize: "0", MustFail: true},
+		{Name: "Invalid retention", Retention: "week", MustFail: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			t.Setenv("JANITOR_INTERVAL", test.Interval)
+			t.Setenv("JANITOR_BATCH_SIZE", test.BatchSize)
+			t.Setenv("REVOKED_SESSION_RETENTION", test.Retention)
+
+			janitor, err := loadJanitor(store.NewMemoryStore())
+
+			if test.MustFail {
+				if err == nil {
+					t.Fatalf("expected error")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if test.Disabled {
+				if janitor != nil {
+					t.Fatalf("janitor is not disabled")
+				}
+				return
+			}
+
+			if janitor.Interval != test.Expected.Interval || janitor.BatchSize != test.Expected.BatchSize || janitor.Retention != test.Expected.Retention {
+				t.Fatalf("unexpected janitor: %#v", janitor)
+			}
+		})
+	}
+}
+
+// failingStore fails every purge
+type failingStore struct {
+	store.SessionStore
+}
+
+func (failingStore) Purge(expiredBefore time.Time, revokedBefore time.Time, limit int) (int64, error) {
+	return 0, errors.New("store is down")
+}
+
+func TestJanitor(t *testing.T) {
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	sessions := store.NewMemoryStore()
+
+	create := func(id string, expiresAt time.Time) {
+		t.Helper()
+
+		err := sessions.Create(store.Session{ID: id, GUID: "guid", TokenHash: "hash", TokenHasher: "bcrypt", ExpiresAt: expiresAt, CreatedAt: now.Add(-48 * time.Hour)})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 5; i++ {
+		create(fmt.Sprintf("expired %v", i), now.Add(-time.Duration(i+1)*time.Hour))
+	}
+
+	create("active", now.Add(time.Hour))
+	create("revoked", now.Add(time.Hour))
+	create("just revoked", now.Add(time.Hour))
+
+	if err := sessions.Revoke("revoked", now.Add(-25*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sessions.Revoke("just revoked", now.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	janitor := Janitor{
+		Sessions:  sessions,
+		Clock:     auth.ClockFunc(func() time.Time { return now }),
+		Interval:  time.Hour,
+		BatchSize: 2,
+		Retention: 24 * time.Hour,
+	}
+
+	purgedBefore := janitorPurgedSessions.Value()
+
+	purged, err := janitor.Purge(context.Background())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if purged != 6 {
+		t.Fatalf("expected 6 purged sessions, got %v", purged)
+	}
+
+	if metric := janitorPurgedSessions.Value() - purgedBefore; metric != 6 {
+		t.Fatalf("purged sessions metric grew by %v", metric)
+	}
+
+	for _, id := range []string{"active", "just revoked"} {
+		if _, err := sessions.Get(id); err != nil {
+			t.Fatalf("session %v was purged: %v", id, err)
+		}
+	}
+
+	// Run purges once and stops when context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	runsBefore := janitorRuns.Value()
+	errorsBefore := janitorErrors.Value()
+
+	janitor.Sessions = failingStore{sessions}
+
+	done := make(chan struct{})
+
+	go func() {
+		janitor.Run(ctx)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("janitor did not stop")
+	}
+
+	if janitorRuns.Value()-runsBefore != 1 {
+		t.Fatalf("unexpected number of janitor runs: %v", janitorRuns.Value()-runsBefore)
+	}
+
+	// Purge of cancelled context does not reach store
+	if janitorErrors.Value() != errorsBefore {
+		t.Fatalf("janitor with cancelled context failed")
+	}
+
+	if janitorLastRun.Value() != now.Unix() {
+		t.Fatalf("unexpected last run metric: %v", janitorLastRun.Value())
+	}
+
+	if _, err := janitor.Purge(context.Background()); err == nil {
+		t.Fatalf("failure of store was not returned")
+	}
+}
+
+func TestMetricsAreNotPublic(t *testing.T) {
+	sessions, err := CreateTestingStore()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer sessions.Close()
+
+	request := httptest.NewRequest(http.MethodGet, "/debug/vars", nil)
+
+	recorder := httptest.NewRecorder()
+	newServeMux(sessions).ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusNotFound {
+		t.Fatalf("metrics must not be served on public port, got: %v", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	newMetricsMux().ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), "janitor_runs") {
+		t.Fatalf("metrics are not served on metrics listener: %v", recorder.Code)
+	}
+}
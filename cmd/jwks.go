@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// JWKSCacheMaxAge is how long API gateways may cache served public keys
+const JWKSCacheMaxAge string = "max-age=300"
+
+func newHandleJWKS() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			w.Write([]byte("must use GET"))
+			return
+		}
+
+		set, err := keyring.JWKSet()
+
+		if err != nil {
+			log.Default().Printf("failed to make JWK set: %v\n", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		answerJson, err := json.Marshal(set)
+
+		if err != nil {
+			log.Default().Println("jwks answer json marshalling error: ", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", JWKSCacheMaxAge)
+		w.Write(answerJson)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"authservice/pkg/auth"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestJWKS(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := auth.NewSigner(key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testingKeyring := keyring
+
+	if keyring, err = newTestingKeyring(signer); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() { keyring = testingKeyring }()
+
+	signingKeyID, _ := keyring.Active()
+
+	request, err := http.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+
+	http.HandlerFunc(newHandleJWKS()).ServeHTTP(recorder, request)
+
+	response := recorder.Result()
+
+	if response.StatusCode != 200 {
+		t.Fatalf("Request failed with code: %v", response.Status)
+	}
+
+	responseBody, err := io.ReadAll(response.Body)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var set auth.JWKSet
+
+	if err = json.Unmarshal(responseBody, &set); err != nil {
+		t.Fatalf("failed to unmarshall answer from server: %v", err)
+	}
+
+	if len(set.Keys) != 1 {
+		t.Fatalf("expected one key, got: %s", responseBody)
+	}
+
+	jwk := set.Keys[0]
+
+	if jwk.Kid != signingKeyID || jwk.Alg != auth.AlgES256 || jwk.Use != auth.KeyUseSignature {
+		t.Fatalf("unexpected key: %#v", jwk)
+	}
+
+	tokens, err := generateAccessRefreshPair("127.0.0.1", "guid", "session")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tokens.AccessToken.Header.Kid != jwk.Kid {
+		t.Fatalf("Access token kid %v does not match published key %v", tokens.AccessToken.Header.Kid, jwk.Kid)
+	}
+
+	if _, err := auth.ParseAccessTokenWith(tokens.AccessToken.Raw, signer); err != nil {
+		t.Fatal(err)
+	}
+}
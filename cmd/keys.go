@@ -0,0 +1,235 @@
+package main
+
+import (
+	"authservice/pkg/auth"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+var keyring *auth.Keyring
+
+// signingAlgs are values accepted in SIGNING_ALG variable
+var signingAlgs = []string{auth.AlgHS256, auth.AlgHS384, auth.AlgHS512, auth.AlgRS256, auth.AlgES256, auth.AlgEdDSA}
+
+func isSigningAlg(alg string) bool {
+	for _, known := range signingAlgs {
+		if alg == known {
+			return true
+		}
+	}
+
+	return false
+}
+
+// loadSecretFile reads HMAC secret from file, surrounding whitespace is ignored
+func loadSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file: %w", err)
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+// newHMACKey creates HMAC key of signingAlg from secret
+func newHMACKey(secret string) (auth.Signer, error) {
+	if len(secret) < MinSecretLength {
+		return nil, fmt.Errorf("Secret is too short: %v, expected at least: %v bytes", len(secret), MinSecretLength)
+	}
+
+	return auth.NewHMACSigner(signingAlg, []byte(secret))
+}
+
+// loadSigningKey loads signing key from SIGNING_KEY_FILE (asymmetric PEM key), SECRET_FILE or SECRET variables
+func loadSigningKey() (kid string, signer auth.Signer, err error) {
+	if keyFile := os.Getenv("SIGNING_KEY_FILE"); keyFile != "" {
+		if signer, err = auth.LoadSignerFromPEMFile(keyFile); err != nil {
+			return "", nil, err
+		}
+
+		if alg := os.Getenv("SIGNING_ALG"); alg != "" && alg != signer.Alg() {
+			return "", nil, fmt.Errorf("SIGNING_ALG %v does not match %v key from SIGNING_KEY_FILE", alg, signer.Alg())
+		}
+	} else {
+		secret := os.Getenv("SECRET")
+
+		if secretFile := os.Getenv("SECRET_FILE"); secretFile != "" {
+			if secret, err = loadSecretFile(secretFile); err != nil {
+				return "", nil, err
+			}
+		}
+
+		signer, err = newHMACKey(secret)
+	}
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	kid, err = auth.KeyID(signer)
+
+	return kid, signer, err
+}
+
+// loadVerifyKeys loads verify-only keys of previous runs: public keys from comma separated VERIFY_KEY_FILES,
+// HMAC secrets from comma separated VERIFY_SECRET_FILES and PREVIOUS_SECRET
+func loadVerifyKeys() ([]auth.Verifier, error) {
+	var verifiers []auth.Verifier
+
+	for _, path := range splitList(os.Getenv("VERIFY_KEY_FILES")) {
+		verifier, err := auth.LoadVerifierFromPEMFile(path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		verifiers = append(verifiers, verifier)
+	}
+
+	var secrets []string
+
+	for _, path := range splitList(os.Getenv("VERIFY_SECRET_FILES")) {
+		secret, err := loadSecretFile(path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		secrets = append(secrets, secret)
+	}
+
+	if secret := os.Getenv("PREVIOUS_SECRET"); secret != "" {
+		secrets = append(secrets, secret)
+	}
+
+	for _, secret := range secrets {
+		verifier, err := newHMACKey(secret)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid previous secret: %w", err)
+		}
+
+		verifiers = append(verifiers, verifier)
+	}
+
+	return verifiers, nil
+}
+
+// splitList splits comma separated list skipping empty items
+func splitList(list string) []string {
+	var items []string
+
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// loadKeyring creates keyring with signing key and verify-only keys of previous runs
+// Verify-only keys are retired after AccessTokenDuration, when tokens signed with them expire,
+// but keep verifying Access tokens presented for refresh as long as Refresh tokens issued with them live
+func loadKeyring() (*auth.Keyring, error) {
+	kid, signer, err := loadSigningKey()
+
+	if err != nil {
+		return nil, err
+	}
+
+	keys := auth.NewKeyring(kid, signer)
+
+	verifiers, err := loadVerifyKeys()
+
+	if err != nil {
+		return nil, err
+	}
+
+	now := clock.Now()
+
+	for _, verifier := range verifiers {
+		verifierID, err := auth.KeyID(verifier)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if verifierID == kid {
+			continue
+		}
+
+		if err = keys.AddVerifier(verifierID, verifier, now.Add(AccessTokenDuration), now.Add(maxIdleTimeout())); err != nil {
+			return nil, err
+		}
+	}
+
+	return keys, nil
+}
+
+// rotateSigningKey reloads signing key and makes it active
+// Previous key keeps verifying tokens for AccessTokenDuration and tokens presented for refresh for longest idle timeout of sessions
+func rotateSigningKey() error {
+	// Environment of running process can not be changed, so secret from SECRET is replaced only by restart
+	if os.Getenv("SIGNING_KEY_FILE") == "" && os.Getenv("SECRET_FILE") == "" {
+		return fmt.Errorf("signing key from SECRET variable can not be rotated without restart, use SECRET_FILE or SIGNING_KEY_FILE")
+	}
+
+	kid, signer, err := loadSigningKey()
+
+	if err != nil {
+		return err
+	}
+
+	if activeID, _ := keyring.Active(); activeID == kid {
+		return fmt.Errorf("signing key %v was not changed", kid)
+	}
+
+	if err = keyring.Rotate(kid, signer, AccessTokenDuration, maxIdleTimeout()); err != nil {
+		return err
+	}
+
+	log.Default().Printf("signing key was rotated, new active key: %v\n", kid)
+
+	return nil
+}
+
+// watchKeyRotation rotates signing key when operator sends SIGHUP after replacing key file
+func watchKeyRotation() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	go func() {
+		for range signals {
+			if err := rotateSigningKey(); err != nil {
+				log.Default().Printf("failed to rotate signing key: %v\n", err)
+			}
+		}
+	}()
+}
+
+// timeValidator returns validator of Access token time claims using clock and tokenLeeway
+func timeValidator() auth.TimeValidator {
+	return auth.TimeValidator{
+		Clock:  clock,
+		Leeway: tokenLeeway,
+	}
+}
+
+// accessTokenVerifier returns verifier of Access tokens issued by this service
+func accessTokenVerifier() auth.TokenVerifier {
+	dpop := dpopProofValidator()
+
+	return auth.TokenVerifier{
+		Keys:     keyring,
+		Issuer:   tokenIssuer,
+		Audience: tokenAudience,
+		Time:     timeValidator(),
+		DPoP:     &dpop,
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"authservice/pkg/auth"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotateSigningKey(t *testing.T) {
+	testingKeyring := keyring
+
+	defer func() { keyring = testingKeyring }()
+
+	secretFile := filepath.Join(t.TempDir(), "secret")
+
+	writeSecret := func(secret string) {
+		if err := os.WriteFile(secretFile, []byte(secret+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeSecret("first secret for rotation")
+
+	t.Setenv("SIGNING_KEY_FILE", "")
+	t.Setenv("SECRET_FILE", secretFile)
+	t.Setenv("VERIFY_KEY_FILES", "")
+	t.Setenv("VERIFY_SECRET_FILES", "")
+	t.Setenv("PREVIOUS_SECRET", "")
+	t.Setenv("SECRET", "")
+
+	keys, err := loadKeyring()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyring = keys
+
+	oldTokens, err := generateAccessRefreshPair("127.0.0.1", "guid", "session")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rotateSigningKey(); err == nil {
+		t.Fatalf("signing key was rotated without changes")
+	}
+
+	writeSecret("second secret for rotation")
+
+	if err := rotateSigningKey(); err != nil {
+		t.Fatal(err)
+	}
+
+	newTokens, err := generateAccessRefreshPair("127.0.0.1", "guid", "session")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if oldTokens.AccessToken.Header.Kid == newTokens.AccessToken.Header.Kid {
+		t.Fatalf("new tokens are signed with old key: %v", newTokens.AccessToken.Header.Kid)
+	}
+
+	for _, tokens := range []string{oldTokens.AccessToken.Raw, newTokens.AccessToken.Raw} {
+		if _, err := keyring.ParseAccessToken(tokens); err != nil {
+			t.Fatalf("failed to verify token after rotation: %v", err)
+		}
+	}
+
+	writeSecret("short")
+
+	if err := rotateSigningKey(); err == nil {
+		t.Fatalf("too short secret was accepted")
+	}
+
+	// Secret from environment is replaced only by restart
+	t.Setenv("SECRET_FILE", "")
+	t.Setenv("SECRET", "third secret for rotation")
+
+	if err := rotateSigningKey(); err == nil {
+		t.Fatalf("signing key from SECRET variable was rotated")
+	}
+}
+
+func TestLoadKeyringPreviousSecrets(t *testing.T) {
+	testingKeyring := keyring
+
+	defer func() { keyring = testingKeyring }()
+
+	secretFile := filepath.Join(t.TempDir(), "previous")
+
+	if err := os.WriteFile(secretFile, []byte("file secret of previous run"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("SIGNING_KEY_FILE", "")
+	t.Setenv("SECRET_FILE", "")
+	t.Setenv("VERIFY_KEY_FILES", "")
+	t.Setenv("VERIFY_SECRET_FILES", "")
+	t.Setenv("PREVIOUS_SECRET", "")
+
+	var tokens []string
+
+	for _, secret := range []string{"file secret of previous run", "env secret of previous run"} {
+		t.Setenv("SECRET", secret)
+
+		keys, err := loadKeyring()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		keyring = keys
+
+		pair, err := generateAccessRefreshPair("127.0.0.1", "guid", "session")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tokens = append(tokens, pair.AccessToken.Raw)
+	}
+
+	t.Setenv("SECRET", "secret of current run")
+	t.Setenv("VERIFY_SECRET_FILES", secretFile)
+	t.Setenv("PREVIOUS_SECRET", "env secret of previous run")
+
+	keys, err := loadKeyring()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, token := range tokens {
+		if _, err := keys.ParseAccessToken(token); err != nil {
+			t.Fatalf("failed to verify token signed with previous secret: %v", err)
+		}
+	}
+
+	t.Setenv("PREVIOUS_SECRET", "short")
+
+	if _, err := loadKeyring(); err == nil {
+		t.Fatalf("too short previous secret was accepted")
+	}
+}
+
+func TestLoadSigningKeyAlg(t *testing.T) {
+	_, _, keyPem := newTestCertificate(t, "signing key")
+
+	keyFile := filepath.Join(t.TempDir(), "key.pem")
+
+	if err := os.WriteFile(keyFile, keyPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("SIGNING_KEY_FILE", keyFile)
+
+	for alg, valid := range map[string]bool{"": true, auth.AlgES256: true, auth.AlgRS256: false, auth.AlgHS512: false} {
+		t.Setenv("SIGNING_ALG", alg)
+
+		if _, _, err := loadSigningKey(); (err == nil) != valid {
+			t.Fatalf("unexpected result of loading ES256 key with SIGNING_ALG %q: %v", alg, err)
+		}
+	}
+
+	if isSigningAlg("none") || !isSigningAlg(auth.AlgEdDSA) {
+		t.Fatalf("signing algorithms are not recognized")
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"authservice/pkg/store"
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// LogoutAllResponse is answer of /v1/logout/all
+type LogoutAllResponse struct {
+	Revoked int64 `json:"revoked"`
+}
+
+func newHandleLogout(sessions store.SessionStore) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		if err := validateAuthRequest(w, r); err != nil {
+			return
+		}
+
+		GUID := r.Header.Get("Guid")
+
+		accessToken, refreshToken, ok := readTokenPair(w, r, GUID)
+
+		if !ok {
+			return
+		}
+
+		session := accessToken.Payload.Session
+
+		if !verifySessionRefreshToken(w, r, sessions, session, GUID, refreshToken) {
+			return
+		}
+
+		if err := sessions.Revoke(session, clock.Now()); err != nil {
+			log.Default().Println("failed to revoke session: ", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// newHandleLogoutAll revokes every session of user presenting token pair
+// With keep_current=true query parameter session of presented pair stays active
+func newHandleLogoutAll(sessions store.SessionStore) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		if err := validateAuthRequest(w, r); err != nil {
+			return
+		}
+
+		GUID := r.Header.Get("Guid")
+
+		accessToken, refreshToken, ok := readTokenPair(w, r, GUID)
+
+		if !ok {
+			return
+		}
+
+		session := accessToken.Payload.Session
+
+		if !verifySessionRefreshToken(w, r, sessions, session, GUID, refreshToken) {
+			return
+		}
+
+		except := ""
+
+		if r.URL.Query().Get("keep_current") == "true" {
+			except = session
+		}
+
+		revoked, err := sessions.RevokeUser(GUID, except, clock.Now())
+
+		if err != nil {
+			log.Default().Println("failed to revoke sessions: ", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		answerJson, err := json.Marshal(LogoutAllResponse{Revoked: revoked})
+
+		if err != nil {
+			log.Default().Println("logout answer json marshalling error: ", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(answerJson)
+	}
+}
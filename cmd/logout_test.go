@@ -0,0 +1,157 @@
+package main
+
+import (
+	api "authservice/pkg/api"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// postTokenPair sends token pair to handler on behalf of guid
+func postTokenPair(t *testing.T, handler http.HandlerFunc, guid string, tokens api.RefreshAccessTokenPair) *httptest.ResponseRecorder {
+	t.Helper()
+
+	requestBody, err := json.Marshal(tokens)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request, err := http.NewRequest(http.MethodPost, "/", bytes.NewBuffer(requestBody))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request.Header.Set("Guid", guid)
+	request.RemoteAddr = "127.0.0.1"
+
+	recorder := httptest.NewRecorder()
+
+	handler.ServeHTTP(recorder, request)
+
+	return recorder
+}
+
+func TestLogout(t *testing.T) {
+	sessions, err := CreateTestingStore()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer sessions.Close()
+
+	tokens := authenticate(t, sessions, "guid")
+	otherTokens := authenticate(t, sessions, "guid")
+
+	logout := http.HandlerFunc(newHandleLogout(sessions))
+	refresh := http.HandlerFunc(newHandleRefresh(sessions, &dummyMailer{}, &dummyPublisher{}))
+
+	if code := postTokenPair(t, logout, "another guid", tokens).Code; code == http.StatusNoContent {
+		t.Fatalf("session was revoked by another user")
+	}
+
+	if code := postTokenPair(t, logout, "guid", tokens).Code; code != http.StatusNoContent {
+		t.Fatalf("logout failed with code: %v", code)
+	}
+
+	if code := postTokenPair(t, refresh, "guid", tokens).Code; code != http.StatusUnauthorized {
+		t.Fatalf("refresh of revoked session must fail with 401, got: %v", code)
+	}
+
+	if code := postTokenPair(t, logout, "guid", tokens).Code; code != http.StatusUnauthorized {
+		t.Fatalf("second logout must fail with 401, got: %v", code)
+	}
+
+	if _, result := introspect(t, sessions, url.Values{"token": {tokens.AccessToken.Raw}}); result.Active {
+		t.Fatalf("Access token of revoked session is active")
+	}
+
+	if code := postTokenPair(t, refresh, "guid", otherTokens).Code; code != http.StatusOK {
+		t.Fatalf("refresh of another session failed with code: %v", code)
+	}
+}
+
+func TestLogoutAll(t *testing.T) {
+	sessions, err := CreateTestingStore()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer sessions.Close()
+
+	current := authenticate(t, sessions, "guid")
+	other := authenticate(t, sessions, "guid")
+	anotherUser := authenticate(t, sessions, "another guid")
+
+	logoutAll := newHandleLogoutAll(sessions)
+	refresh := http.HandlerFunc(newHandleRefresh(sessions, &dummyMailer{}, &dummyPublisher{}))
+
+	requestBody, err := json.Marshal(current)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request, err := http.NewRequest(http.MethodPost, "/v1/logout/all?keep_current=true", bytes.NewBuffer(requestBody))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request.Header.Set("Guid", "guid")
+
+	recorder := httptest.NewRecorder()
+
+	http.HandlerFunc(logoutAll).ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("logout of all sessions failed with code: %v", recorder.Code)
+	}
+
+	var result LogoutAllResponse
+
+	if err = json.Unmarshal(recorder.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Revoked != 1 {
+		t.Fatalf("expected one revoked session, got: %v", result.Revoked)
+	}
+
+	if code := postTokenPair(t, refresh, "guid", other).Code; code != http.StatusUnauthorized {
+		t.Fatalf("refresh of revoked session must fail with 401, got: %v", code)
+	}
+
+	if _, result := introspect(t, sessions, url.Values{"token": {other.AccessToken.Raw}}); result.Active {
+		t.Fatalf("Access token of revoked session is active")
+	}
+
+	if _, result := introspect(t, sessions, url.Values{"token": {current.AccessToken.Raw}}); !result.Active {
+		t.Fatalf("current session was revoked")
+	}
+
+	revoked, err := sessions.RevokeUser("guid", "", time.Now())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if revoked != 1 {
+		t.Fatalf("expected current session to be revoked, got: %v", revoked)
+	}
+
+	if code := postTokenPair(t, refresh, "guid", current).Code; code != http.StatusUnauthorized {
+		t.Fatalf("refresh of revoked session must fail with 401, got: %v", code)
+	}
+
+	if code := postTokenPair(t, refresh, "another guid", anotherUser).Code; code != http.StatusOK {
+		t.Fatalf("session of another user was revoked, refresh failed with code: %v", code)
+	}
+}
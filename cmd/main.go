@@ -1,38 +1,328 @@
 package main
 
 import (
+	"authservice/pkg/auth"
+	"authservice/pkg/events"
 	"authservice/pkg/mail"
+	"authservice/pkg/store"
+	"context"
+	"errors"
+	"expvar"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 )
 
-var secret string
+// signingAlg is HMAC algorithm of Access tokens, selected with SIGNING_ALG variable
+// With SIGNING_KEY_FILE algorithm is defined by key, SIGNING_ALG if set has to match it
+var signingAlg string = auth.AlgHS512
+
+// Access token serialization formats, selected with ACCESS_TOKEN_FORMAT variable
+const (
+	AccessTokenFormatCompact string = "compact"
+	AccessTokenFormatLegacy  string = "legacy"
+)
+
+var accessTokenFormat string = AccessTokenFormatCompact
+
+// Refresh token formats, selected with REFRESH_TOKEN_FORMAT variable
+const (
+	// RefreshTokenFormatLegacy is base64 encoded JSON carrying client IP and Access token signature
+	RefreshTokenFormatLegacy string = "legacy"
+	// RefreshTokenFormatOpaque is random string with session lookup prefix, its metadata is stored in sessions table
+	RefreshTokenFormatOpaque string = "opaque"
+)
+
+var refreshTokenFormat string = RefreshTokenFormatLegacy
+
+// tokenIssuer and tokenAudience are "iss" and "aud" claims of Access tokens,
+// configured with TOKEN_ISSUER and TOKEN_AUDIENCE variables
+var tokenIssuer string = "authservice"
+var tokenAudience string
+
+// tokenScope is "scope" claim of Access tokens, configured with TOKEN_SCOPE variable
+var tokenScope string
+
+// introspectionSecret protects /v1/introspect, it is set with INTROSPECTION_SECRET variable
+// and resource servers pass it as bearer token, without it introspection is refused
+var introspectionSecret string
 
 const MinSecretLength int = 16
 const AccessTokenDuration time.Duration = time.Hour * 2
 const RefreshTokenDuration time.Duration = time.Hour * 24 * 30
 
+// ShutdownTimeout is time in-flight requests are given to complete on SIGINT or SIGTERM
+const ShutdownTimeout time.Duration = time.Second * 15
+
+// clock is source of current time for tokens and sessions, it is replaced in tests
+var clock auth.Clock = auth.SystemClock{}
+
+// tokenLeeway is tolerated clock skew when Access token time claims are checked, configured with CLOCK_SKEW_LEEWAY variable
+var tokenLeeway time.Duration = 0
+
 var mailer mail.Mailer = mail.SimpleMailer{}
 
+var securityEvents events.Publisher = events.LogPublisher{}
+
 func main() {
-	secret = os.Getenv("SECRET")
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	if len(secret) < MinSecretLength {
-		msg := fmt.Sprintf("Secret is too short: %v, expected at least: %v bytes", len(secret), MinSecretLength)
+	if format := os.Getenv("ACCESS_TOKEN_FORMAT"); format != "" {
+		if format != AccessTokenFormatCompact && format != AccessTokenFormatLegacy {
+			msg := fmt.Sprintf("Unknown access token format: %v, expected %v or %v", format, AccessTokenFormatCompact, AccessTokenFormatLegacy)
+			panic(msg)
+		}
+
+		accessTokenFormat = format
+	}
+
+	if format := os.Getenv("REFRESH_TOKEN_FORMAT"); format != "" {
+		if format != RefreshTokenFormatLegacy && format != RefreshTokenFormatOpaque {
+			msg := fmt.Sprintf("Unknown refresh token format: %v, expected %v or %v", format, RefreshTokenFormatLegacy, RefreshTokenFormatOpaque)
+			panic(msg)
+		}
+
+		refreshTokenFormat = format
+	}
+
+	if alg := os.Getenv("SIGNING_ALG"); alg != "" {
+		if !isSigningAlg(alg) {
+			msg := fmt.Sprintf("Unknown signing algorithm: %v, expected one of %v", alg, strings.Join(signingAlgs, ", "))
+			panic(msg)
+		}
+
+		signingAlg = alg
+	}
+
+	if issuer := os.Getenv("TOKEN_ISSUER"); issuer != "" {
+		tokenIssuer = issuer
+	}
+
+	if gracePeriod := os.Getenv("REFRESH_GRACE_PERIOD"); gracePeriod != "" {
+		duration, err := time.ParseDuration(gracePeriod)
+
+		if err != nil || duration < 0 {
+			msg := fmt.Sprintf("Invalid refresh grace period: %v, expected duration such as 30s", gracePeriod)
+			panic(msg)
+		}
+
+		refreshGracePeriod = duration
+	}
+
+	if leeway := os.Getenv("CLOCK_SKEW_LEEWAY"); leeway != "" {
+		duration, err := time.ParseDuration(leeway)
+
+		if err != nil || duration < 0 {
+			msg := fmt.Sprintf("Invalid clock skew leeway: %v, expected duration such as 30s", leeway)
+			panic(msg)
+		}
+
+		tokenLeeway = duration
+	}
+
+	if secret := os.Getenv("DPOP_NONCE_SECRET"); secret != "" {
+		if len(secret) < MinSecretLength {
+			msg := fmt.Sprintf("DPoP nonce secret is too short, expected at least %v bytes", MinSecretLength)
+			panic(msg)
+		}
+
+		dpopNonces = auth.NewDPoPNonces([]byte(secret), 0)
+	}
+
+	if required := os.Getenv("DPOP_REQUIRED"); required != "" {
+		value, err := strconv.ParseBool(required)
+
+		if err != nil {
+			msg := fmt.Sprintf("Invalid DPOP_REQUIRED value: %v, expected true or false", required)
+			panic(msg)
+		}
+
+		dpopRequired = value
+	}
+
+	publicURL = os.Getenv("PUBLIC_URL")
+	tokenAudience = os.Getenv("TOKEN_AUDIENCE")
+	tokenScope = os.Getenv("TOKEN_SCOPE")
+	introspectionSecret = os.Getenv("INTROSPECTION_SECRET")
+
+	if introspectionSecret == "" {
+		log.Default().Println("INTROSPECTION_SECRET is not set, /v1/introspect refuses all requests")
+	} else if len(introspectionSecret) < MinSecretLength {
+		msg := fmt.Sprintf("Introspection secret is too short, expected at least %v bytes", MinSecretLength)
 		panic(msg)
 	}
 
-	if err := ConnectDB(); err != nil {
+	// Session policies define how long previous signing keys are kept for refresh
+	var err error
+
+	defaultSessionPolicy, sessionPolicies, err = loadSessionPolicies()
+
+	if err != nil {
 		panic(err)
 	}
 
-	defer DB.Close()
+	keys, err := loadKeyring()
+
+	if err != nil {
+		panic(err)
+	}
+
+	keyring = keys
+
+	watchKeyRotation()
+
+	tokenHasher, tokenHashers, err = loadTokenHashers()
 
-	http.HandleFunc("/v1/auth", newHandleAuth(DB))
+	if err != nil {
+		panic(err)
+	}
+
+	sessionLimit, err = loadSessionLimit()
+
+	if err != nil {
+		panic(err)
+	}
+
+	tlsConfig, err := loadTLSConfig()
+
+	if err != nil {
+		panic(err)
+	}
+
+	sessions, err := openSessionStore()
+
+	if err != nil {
+		panic(err)
+	}
+
+	defer sessions.Close()
+
+	if err := migrateOnStart(sessions); err != nil {
+		panic(err)
+	}
+
+	handler := newServeMux(sessions)
+
+	janitor, err := loadJanitor(sessions)
+
+	if err != nil {
+		panic(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	var background sync.WaitGroup
+
+	if janitor != nil {
+		background.Add(1)
+
+		go func() {
+			defer background.Done()
+			janitor.Run(ctx)
+		}()
+	}
+
+	// Metrics are served only on separate listener, so they are not exposed on public port
+	if metricsAddr := os.Getenv("METRICS_ADDR"); metricsAddr != "" {
+		metricsServer := &http.Server{Addr: metricsAddr, Handler: newMetricsMux()}
+
+		background.Add(1)
+
+		go func() {
+			defer background.Done()
+
+			if err := metricsServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				log.Default().Printf("metrics server failed: %v\n", err)
+			}
+		}()
+
+		go func() {
+			<-ctx.Done()
+			metricsServer.Close()
+		}()
+	}
+
+	server := &http.Server{Addr: ":5555", Handler: handler, TLSConfig: tlsConfig}
+
+	// ListenAndServe returns as soon as shutdown starts, shutdown is over when in-flight requests are drained
+	shutdownDone := make(chan struct{})
+
+	go func() {
+		defer close(shutdownDone)
+
+		<-ctx.Done()
+
+		log.Default().Println("shutting down")
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+		defer cancel()
+
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Default().Printf("failed to shut down server: %v\n", err)
+		}
+	}()
+
+	if tlsConfig != nil {
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+
+	if !errors.Is(err, http.ErrServerClosed) {
+		log.Default().Printf("server failed: %v\n", err)
+		stop()
+	}
+
+	<-shutdownDone
+
+	// Janitor finishes its batch before sessions store is closed
+	background.Wait()
+}
+
+// newServeMux registers public routes of service
+// DefaultServeMux is not used since packages such as expvar register debug handlers on it
+func newServeMux(sessions store.SessionStore) *http.ServeMux {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/v1/auth", newHandleAuth(sessions, mailer, securityEvents))
+
+	mux.HandleFunc("/.well-known/jwks.json", newHandleJWKS())
+
+	mux.HandleFunc("/v1/refresh", newHandleRefresh(sessions, mailer, securityEvents))
+
+	mux.HandleFunc("/v1/introspect", newHandleIntrospect(sessions))
+
+	mux.HandleFunc("/v1/logout", newHandleLogout(sessions))
+
+	mux.HandleFunc("/v1/logout/all", newHandleLogoutAll(sessions))
+
+	verifier := accessTokenVerifier()
+
+	mux.Handle("GET /v1/sessions", verifier.Middleware(newHandleListSessions(sessions)))
+
+	mux.Handle("DELETE /v1/sessions/{id}", verifier.Middleware(newHandleRevokeSession(sessions)))
+
+	return mux
+}
 
-	http.HandleFunc("/v1/refresh", newHandleRefresh(DB, mailer))
+// newMetricsMux serves expvar metrics for METRICS_ADDR listener
+func newMetricsMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
 
-	http.ListenAndServe(":5555", nil)
+	return mux
 }
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"authservice/pkg/store"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// DefaultSessionMaxLifetime is absolute session age after which refresh is refused
+const DefaultSessionMaxLifetime time.Duration = time.Hour * 24 * 90
+
+// SessionPolicy limits session lifetime
+type SessionPolicy struct {
+	// IdleTimeout is lifetime of Refresh token, it is reset on each refresh
+	IdleTimeout time.Duration
+	// MaxLifetime is absolute session age counted from creation, zero means unlimited
+	MaxLifetime time.Duration
+	// Secret authenticates client selecting policy with Client-Id, empty for public clients
+	Secret string
+}
+
+// Expires returns moment Refresh token issued at now expires, it never exceeds absolute session lifetime
+func (p SessionPolicy) Expires(createdAt time.Time, now time.Time) time.Time {
+	expires := now.Add(p.IdleTimeout)
+
+	if p.MaxLifetime > 0 && !createdAt.IsZero() {
+		if deadline := createdAt.Add(p.MaxLifetime); deadline.Before(expires) {
+			return deadline
+		}
+	}
+
+	return expires
+}
+
+// Exceeded reports whether session created at createdAt reached absolute lifetime
+// Sessions without creation time have no absolute limit, migration backfills it for sessions created before it was stored
+func (p SessionPolicy) Exceeded(createdAt time.Time, now time.Time) bool {
+	if p.MaxLifetime <= 0 || createdAt.IsZero() {
+		return false
+	}
+
+	return !now.Before(createdAt.Add(p.MaxLifetime))
+}
+
+// defaultSessionPolicy is applied to clients without own policy,
+// configured with SESSION_IDLE_TIMEOUT and SESSION_MAX_LIFETIME variables
+var defaultSessionPolicy SessionPolicy = SessionPolicy{
+	IdleTimeout: RefreshTokenDuration,
+	MaxLifetime: DefaultSessionMaxLifetime,
+}
+
+// sessionPolicies are policies of clients by client id, configured with SESSION_POLICIES variable
+var sessionPolicies map[string]SessionPolicy = map[string]SessionPolicy{}
+
+// sessionPolicy returns policy of client, clients without own policy get default one
+func sessionPolicy(clientID string) SessionPolicy {
+	if policy, ok := sessionPolicies[clientID]; ok {
+		return policy
+	}
+
+	return defaultSessionPolicy
+}
+
+// maxIdleTimeout returns longest idle timeout of session policies
+// Refresh token is presented with Access token issued together with it at most that long after issuance
+func maxIdleTimeout() time.Duration {
+	longest := defaultSessionPolicy.IdleTimeout
+
+	for _, policy := range sessionPolicies {
+		if policy.IdleTimeout > longest {
+			longest = policy.IdleTimeout
+		}
+	}
+
+	return longest
+}
+
+// sessionClient returns id of client whose policy applies to session requested with r, empty for default policy
+// Client-Id header is not authenticated, so it selects policy only of clients explicitly configured as public
+// or presenting their secret in Client-Secret header, otherwise any caller could pick most permissive policy
+func sessionClient(r *http.Request) string {
+	clientID := r.Header.Get("Client-Id")
+
+	policy, ok := sessionPolicies[clientID]
+
+	if !ok {
+		return ""
+	}
+
+	if policy.Secret != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Client-Secret")), []byte(policy.Secret)) != 1 {
+		log.Default().Printf("client %v is not authenticated, default session policy is applied\n", clientID)
+		return ""
+	}
+
+	return clientID
+}
+
+// sessionPolicyConfig is policy of client in SESSION_POLICIES, durations use time.ParseDuration format
+// Omitted values are taken from default policy. Client must either have secret or be marked public
+type sessionPolicyConfig struct {
+	IdleTimeout string `json:"idle_timeout"`
+	MaxLifetime string `json:"max_lifetime"`
+	Secret      string `json:"secret"`
+	Public      bool   `json:"public"`
+}
+
+func parsePolicyDuration(value string, fallback time.Duration) (time.Duration, error) {
+	if value == "" {
+		return fallback, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if duration < 0 {
+		return 0, fmt.Errorf("negative duration: %v", value)
+	}
+
+	return duration, nil
+}
+
+// loadSessionPolicies loads default policy from SESSION_IDLE_TIMEOUT and SESSION_MAX_LIFETIME
+// and policies of clients from SESSION_POLICIES JSON object, for example {"mobile": {"idle_timeout": "720h", "secret": "..."}, "web": {"idle_timeout": "12h", "public": true}}
+func loadSessionPolicies() (SessionPolicy, map[string]SessionPolicy, error) {
+	var err error
+
+	policy := SessionPolicy{}
+
+	if policy.IdleTimeout, err = parsePolicyDuration(os.Getenv("SESSION_IDLE_TIMEOUT"), RefreshTokenDuration); err != nil {
+		return SessionPolicy{}, nil, fmt.Errorf("invalid SESSION_IDLE_TIMEOUT: %w", err)
+	}
+
+	if policy.MaxLifetime, err = parsePolicyDuration(os.Getenv("SESSION_MAX_LIFETIME"), DefaultSessionMaxLifetime); err != nil {
+		return SessionPolicy{}, nil, fmt.Errorf("invalid SESSION_MAX_LIFETIME: %w", err)
+	}
+
+	if policy.IdleTimeout == 0 {
+		return SessionPolicy{}, nil, fmt.Errorf("invalid SESSION_IDLE_TIMEOUT: must be positive")
+	}
+
+	policies := map[string]SessionPolicy{}
+
+	policiesJson := os.Getenv("SESSION_POLICIES")
+
+	if policiesJson == "" {
+		return policy, policies, nil
+	}
+
+	configs := map[string]sessionPolicyConfig{}
+
+	if err = json.Unmarshal([]byte(policiesJson), &configs); err != nil {
+		return SessionPolicy{}, nil, fmt.Errorf("failed to unmarshall SESSION_POLICIES: %w", err)
+	}
+
+	for clientID, config := range configs {
+		clientPolicy := SessionPolicy{}
+
+		if clientPolicy.IdleTimeout, err = parsePolicyDuration(config.IdleTimeout, policy.IdleTimeout); err != nil {
+			return SessionPolicy{}, nil, fmt.Errorf("invalid idle_timeout of client %v: %w", clientID, err)
+		}
+
+		if clientPolicy.MaxLifetime, err = parsePolicyDuration(config.MaxLifetime, policy.MaxLifetime); err != nil {
+			return SessionPolicy{}, nil, fmt.Errorf("invalid max_lifetime of client %v: %w", clientID, err)
+		}
+
+		if clientPolicy.IdleTimeout == 0 {
+			return SessionPolicy{}, nil, fmt.Errorf("invalid idle_timeout of client %v: must be positive", clientID)
+		}
+
+		switch {
+		case config.Secret != "" && config.Public:
+			return SessionPolicy{}, nil, fmt.Errorf("client %v can not be public and have secret", clientID)
+		case config.Secret == "" && !config.Public:
+			return SessionPolicy{}, nil, fmt.Errorf("client %v must have secret or be marked public", clientID)
+		case len(config.Secret) > 0 && len(config.Secret) < MinSecretLength:
+			return SessionPolicy{}, nil, fmt.Errorf("secret of client %v is too short, expected at least %v bytes", clientID, MinSecretLength)
+		}
+
+		clientPolicy.Secret = config.Secret
+
+		policies[clientID] = clientPolicy
+	}
+
+	return policy, policies, nil
+}
+
+// sessionLimit caps number of active sessions of user, configured with SESSION_LIMIT and SESSION_LIMIT_POLICY variables
+var sessionLimit store.SessionLimit = store.SessionLimit{Policy: store.LimitReject}
+
+// loadSessionLimit loads maximum number of active sessions of user from SESSION_LIMIT, zero or unset means unlimited,
+// and policy applied when it is reached from SESSION_LIMIT_POLICY: reject (default) or evict
+func loadSessionLimit() (store.SessionLimit, error) {
+	limit := store.SessionLimit{Policy: store.LimitReject}
+
+	if value := os.Getenv("SESSION_LIMIT"); value != "" {
+		max, err := strconv.Atoi(value)
+
+		if err != nil || max < 0 {
+			return store.SessionLimit{}, fmt.Errorf("invalid SESSION_LIMIT: %v, expected non-negative number", value)
+		}
+
+		limit.Max = max
+	}
+
+	if policy := os.Getenv("SESSION_LIMIT_POLICY"); policy != "" {
+		if policy != string(store.LimitReject) && policy != string(store.LimitEvict) {
+			return store.SessionLimit{}, fmt.Errorf("unknown SESSION_LIMIT_POLICY: %v, expected %v or %v", policy, store.LimitReject, store.LimitEvict)
+		}
+
+		limit.Policy = store.LimitPolicy(policy)
+	}
+
+	return limit, nil
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"authservice/pkg/store"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionPolicy(t *testing.T) {
+	policy := SessionPolicy{IdleTimeout: time.Hour, MaxLifetime: 24 * time.Hour}
+	createdAt := testNow
+
+	if expires := policy.Expires(createdAt, createdAt); !expires.Equal(createdAt.Add(time.Hour)) {
+		t.Fatalf("new session must expire after idle timeout, got: %v", expires)
+	}
+
+	now := createdAt.Add(23*time.Hour + 30*time.Minute)
+
+	if expires := policy.Expires(createdAt, now); !expires.Equal(createdAt.Add(24 * time.Hour)) {
+		t.Fatalf("refresh must not extend session past absolute lifetime, got: %v", expires)
+	}
+
+	if policy.Exceeded(createdAt, now) {
+		t.Fatalf("session lifetime exceeded too early")
+	}
+
+	if !policy.Exceeded(createdAt, createdAt.Add(24*time.Hour)) {
+		t.Fatalf("session lifetime was not exceeded")
+	}
+
+	if policy.Exceeded(time.Time{}, now) {
+		t.Fatalf("session without creation time has no absolute limit")
+	}
+
+	unlimited := SessionPolicy{IdleTimeout: time.Hour}
+
+	if unlimited.Exceeded(createdAt, createdAt.Add(24*365*time.Hour)) {
+		t.Fatalf("session without max lifetime has no absolute limit")
+	}
+}
+
+func TestLoadSessionPolicies(t *testing.T) {
+	t.Setenv("SESSION_IDLE_TIMEOUT", "2h")
+	t.Setenv("SESSION_MAX_LIFETIME", "")
+	t.Setenv("SESSION_POLICIES", `{"mobile": {"max_lifetime": "48h", "secret": "mobile client secret"}, "web": {"idle_timeout": "30m", "max_lifetime": "12h", "public": true}}`)
+
+	policy, policies, err := loadSessionPolicies()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if policy != (SessionPolicy{IdleTimeout: 2 * time.Hour, MaxLifetime: DefaultSessionMaxLifetime}) {
+		t.Fatalf("unexpected default policy: %#v", policy)
+	}
+
+	if policies["mobile"] != (SessionPolicy{IdleTimeout: 2 * time.Hour, MaxLifetime: 48 * time.Hour, Secret: "mobile client secret"}) {
+		t.Fatalf("omitted idle timeout must be taken from default policy: %#v", policies["mobile"])
+	}
+
+	if policies["web"] != (SessionPolicy{IdleTimeout: 30 * time.Minute, MaxLifetime: 12 * time.Hour}) {
+		t.Fatalf("unexpected web policy: %#v", policies["web"])
+	}
+
+	invalid := []string{
+		`{"web": {"idle_timeout": "forever", "public": true}}`,
+		`{"web": {"max_lifetime": "-1h", "public": true}}`,
+		`[]`,
+		`{"web": {"idle_timeout": "1h"}}`,
+		`{"web": {"public": true, "secret": "mobile client secret"}}`,
+		`{"web": {"secret": "short"}}`,
+	}
+
+	for _, invalid := range invalid {
+		t.Setenv("SESSION_POLICIES", invalid)
+
+		if _, _, err := loadSessionPolicies(); err == nil {
+			t.Errorf("invalid SESSION_POLICIES %v was loaded", invalid)
+		}
+	}
+}
+
+func TestSessionClient(t *testing.T) {
+	defer func() { sessionPolicies = map[string]SessionPolicy{} }()
+
+	sessionPolicies = map[string]SessionPolicy{
+		"web":    {IdleTimeout: time.Hour},
+		"mobile": {IdleTimeout: 90 * 24 * time.Hour, Secret: "mobile client secret"},
+	}
+
+	tests := []struct {
+		ClientID string
+		Secret   string
+		Expected string
+	}{
+		{ClientID: "web", Expected: "web"},
+		{ClientID: "mobile", Secret: "mobile client secret", Expected: "mobile"},
+		{ClientID: "mobile", Expected: ""},
+		{ClientID: "mobile", Secret: "wrong secret", Expected: ""},
+		{ClientID: "unknown", Expected: ""},
+		{Expected: ""},
+	}
+
+	for _, test := range tests {
+		request := httptest.NewRequest(http.MethodPost, "/v1/auth", nil)
+
+		if test.ClientID != "" {
+			request.Header.Set("Client-Id", test.ClientID)
+		}
+
+		if test.Secret != "" {
+			request.Header.Set("Client-Secret", test.Secret)
+		}
+
+		if clientID := sessionClient(request); clientID != test.Expected {
+			t.Errorf("client %q with secret %q got policy of %q, expected %q", test.ClientID, test.Secret, clientID, test.Expected)
+		}
+	}
+}
+
+func TestSessionLifetime(t *testing.T) {
+	now := testNow
+	fixClock(t, &now)
+
+	defer func() { sessionPolicies = map[string]SessionPolicy{} }()
+
+	sessionPolicies = map[string]SessionPolicy{
+		"web": {IdleTimeout: time.Hour, MaxLifetime: 2 * time.Hour},
+	}
+
+	sessions, err := CreateTestingStore()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer sessions.Close()
+
+	request, err := http.NewRequest(http.MethodPost, "/v1/auth", strings.NewReader(""))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request.Header.Set("Guid", "guid")
+	request.Header.Set("Client-Id", "web")
+	request.RemoteAddr = "127.0.0.1"
+
+	recorder := httptest.NewRecorder()
+
+	http.HandlerFunc(newHandleAuth(sessions, &dummyMailer{}, &dummyPublisher{})).ServeHTTP(recorder, request)
+
+	first := readTokenPairResponse(t, recorder)
+	id := first.AccessToken.Payload.Session
+
+	session, err := sessions.Get(id)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if session.ClientID != "web" || session.CreatedAt.IsZero() {
+		t.Fatalf("client and creation time were not stored: %#v", session)
+	}
+
+	if expires := session.ExpiresAt.Sub(session.CreatedAt); expires > time.Hour+time.Second {
+		t.Fatalf("session expires after %v, expected client idle timeout", expires)
+	}
+
+	refresh := http.HandlerFunc(newHandleRefresh(sessions, &dummyMailer{}, &dummyPublisher{}))
+
+	// Session created 90 minutes ago can be extended only until its absolute lifetime
+	createdAt := now.Add(-90 * time.Minute)
+
+	if _, err := sessions.DB().Exec("UPDATE sessions SET created_at = $1 WHERE session_id = $2", createdAt, id); err != nil {
+		t.Fatal(err)
+	}
+
+	second := readTokenPairResponse(t, postTokenPair(t, refresh, "guid", first))
+
+	if session, err = sessions.Get(id); err != nil {
+		t.Fatal(err)
+	}
+
+	if session.ExpiresAt.After(createdAt.Add(2*time.Hour + time.Second)) {
+		t.Fatalf("refresh extended session past absolute lifetime: %v", session.ExpiresAt)
+	}
+
+	if _, err := sessions.DB().Exec("UPDATE sessions SET created_at = $1 WHERE session_id = $2", now.Add(-3*time.Hour), id); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := postTokenPair(t, refresh, "guid", second).Code; code != http.StatusUnauthorized {
+		t.Fatalf("refresh of session past absolute lifetime must fail with 401, got: %v", code)
+	}
+
+	if session, err = sessions.Get(id); err != nil {
+		t.Fatal(err)
+	}
+
+	if session.Generation != 1 {
+		t.Fatalf("session past absolute lifetime was rotated")
+	}
+}
+
+func TestLoadSessionLimit(t *testing.T) {
+	t.Setenv("SESSION_LIMIT", "")
+	t.Setenv("SESSION_LIMIT_POLICY", "")
+
+	limit, err := loadSessionLimit()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if limit != (store.SessionLimit{Policy: store.LimitReject}) {
+		t.Fatalf("sessions must be unlimited by default: %#v", limit)
+	}
+
+	t.Setenv("SESSION_LIMIT", "5")
+	t.Setenv("SESSION_LIMIT_POLICY", "evict")
+
+	if limit, err = loadSessionLimit(); err != nil || limit != (store.SessionLimit{Max: 5, Policy: store.LimitEvict}) {
+		t.Fatalf("unexpected session limit: %#v %v", limit, err)
+	}
+
+	for _, invalid := range [][2]string{{"-1", "reject"}, {"many", "reject"}, {"5", "oldest"}} {
+		t.Setenv("SESSION_LIMIT", invalid[0])
+		t.Setenv("SESSION_LIMIT_POLICY", invalid[1])
+
+		if _, err := loadSessionLimit(); err == nil {
+			t.Errorf("invalid session limit %v was loaded", invalid)
+		}
+	}
+}
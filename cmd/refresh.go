@@ -3,8 +3,10 @@ package main
 import (
 	api "authservice/pkg/api"
 	"authservice/pkg/auth"
+	"authservice/pkg/events"
 	"authservice/pkg/mail"
-	"database/sql"
+	"authservice/pkg/store"
+	"crypto/hmac"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -14,127 +16,294 @@ import (
 	"time"
 )
 
-func newHandleRefresh(DB *sql.DB, mailer mail.Mailer) func(w http.ResponseWriter, r *http.Request) {
-	return func(w http.ResponseWriter, r *http.Request) {
+// readTokenPair loads Refresh Access token pair from request body and checks that tokens are genuine and bound to each other
+// On failure it writes response and returns false
+func readTokenPair(w http.ResponseWriter, r *http.Request, GUID string) (accessToken api.AccessToken, refreshToken api.SessionRefreshToken, ok bool) {
+	body, err := io.ReadAll(r.Body)
 
-		if err := validateAuthRequest(w, r); err != nil {
-			return
-		}
+	if err != nil {
+		log.Default().Printf("failed to read all data from request body: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 
-		GUID := r.Header.Get("Guid")
-		ip := r.RemoteAddr
+	var p api.RefreshAccessTokenPair
 
-		body, err := io.ReadAll(r.Body)
+	err = json.Unmarshal(body, &p)
 
-		if err != nil {
-			log.Default().Printf("failed to read all data from request body: %v\n", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
+	if err != nil {
+		log.Default().Printf("failed to unmarshall RefreshAccessTokenPair from request: %v\n", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("there is no Refresh Access token pair in request"))
+		return
+	}
 
-		var p api.RefreshAccessTokenPair
+	refreshToken, err = api.LoadRefreshToken(p.RefreshToken)
 
-		err = json.Unmarshal(body, &p)
+	if err != nil {
+		log.Default().Printf("failed to load Refresh token from request: %v\n", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("there is incorrect Refresh token in request"))
+		return
+	}
 
-		if err != nil {
-			log.Default().Printf("failed to unmarshall RefreshAccessTokenPair from request: %v\n", err)
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("there is no Refresh Access token pair in request"))
-			return
-		}
+	accessToken = p.AccessToken
 
-		refreshToken, err := api.LoadRefreshTokenFromBase64(p.RefreshToken)
+	// Access token of client idle across key rotation is signed with key retired for Access tokens but kept for refresh
+	if err = keyring.VerifyRefreshedAccessToken(accessToken); err != nil {
+		log.Default().Printf("attempted to use access token with incorrect signature: %v\n", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("there is incorrect Refresh token in request"))
+		return
+	}
 
-		if err != nil {
-			log.Default().Printf("failed to load Refresh token from request: %v\n", err)
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("there is incorrect Refresh token in request"))
-			return
-		}
+	// Access token is expected to be expired on refresh, here only tokens issued in future are rejected,
+	// age of expired token is bounded by session policy when session is loaded
+	if err = timeValidator().ValidateIssued(accessToken); err != nil {
+		log.Default().Printf("attempted to use Access token which is not valid yet: %v\n", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Access token is not valid yet"))
+		return
+	}
 
-		if time.Now().After(refreshToken.Header.Expires) {
-			msg := "passed expired refresh token"
-			log.Default().Printf(msg)
+	// Tokens issued before subject claim was introduced have no subject
+	if accessToken.Payload.Subject != "" && accessToken.Payload.Subject != GUID {
+		log.Default().Printf("attempted to use Access token of another subject\n")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Access token belongs to another user"))
+		return
+	}
+
+	if legacyToken, isLegacy := refreshToken.(api.RefreshToken); isLegacy && legacyToken.Payload.AccessTokenSignature != accessToken.Signature {
+		log.Default().Printf("attempted to use refresh token with signature not equal to signature of access token\n")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("there is incorrect Refresh token in request"))
+		return
+	}
+
+	// Tokens issued before sessions were introduced have no session
+	if session, _ := refreshToken.Lookup(); session != "" && session != accessToken.Payload.Session {
+		log.Default().Printf("attempted to use refresh token of another session\n")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("there is incorrect Refresh token in request"))
+		return
+	}
+
+	return accessToken, refreshToken, true
+}
+
+// loadActiveSession loads session and checks with stored revocation state and expiry that it can be used
+// On failure it writes response and returns false
+func loadActiveSession(w http.ResponseWriter, sessions store.SessionStore, id string, now time.Time) (store.Session, bool) {
+	session, err := sessions.Get(id)
+
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			log.Printf("Refresh token session not found")
 			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte(msg))
-			return
+			w.Write([]byte("session not found"))
+		} else {
+			log.Printf("error when trying to load session from database: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
 		}
+		return store.Session{}, false
+	}
 
-		// TODO add user email from DB
-		if refreshToken.Payload.Ip != ip {
-			msg := fmt.Sprintf("warning attempting token refresh from another IP.\nOld ip: %v\nNew ip: %v", refreshToken.Payload.Ip, ip)
-			mailer.SendWarning("authwarning@example.com", "user@example.com", msg)
+	if session.Revoked() {
+		log.Printf("attempted to use revoked session")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("session not found"))
+		return store.Session{}, false
+	}
+
+	if !session.Active(now) {
+		msg := "passed expired refresh token"
+		log.Default().Printf(msg)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(msg))
+		return store.Session{}, false
+	}
+
+	return session, true
+}
+
+// verifySessionBinding checks that request is made by holder of key or client certificate session is bound to
+// On failure it writes response and returns false
+func verifySessionBinding(w http.ResponseWriter, r *http.Request, session store.Session) bool {
+	// Refresh token of DPoP bound session is usable only with proof of bound key
+	if session.DPoPJKT != "" {
+		if _, ok := readDPoPProof(w, r, session.DPoPJKT); !ok {
+			return false
 		}
+	}
+
+	// Refresh token of certificate bound session is usable only over connection with same client certificate
+	if session.CertThumbprint != "" && !hmac.Equal([]byte(auth.ClientCertificateThumbprint(r)), []byte(session.CertThumbprint)) {
+		msg := "client certificate does not match session certificate"
+		log.Default().Println(msg)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(msg))
+		return false
+	}
 
-		accessToken := p.AccessToken
+	return true
+}
 
-		accessTokenSignature, err := auth.CalculateAccessTokenHash(accessToken, secret)
+// verifySessionRefreshToken checks Refresh token against hash stored for active session and session binding
+// On failure it writes response and returns false
+func verifySessionRefreshToken(w http.ResponseWriter, r *http.Request, sessions store.SessionStore, id string, GUID string, refreshToken api.SessionRefreshToken) bool {
+	session, ok := loadActiveSession(w, sessions, id, clock.Now())
 
-		if err != nil {
-			log.Default().Printf("failed to calculate Access token signature: %v\n", err)
+	if !ok {
+		return false
+	}
+
+	if !verifySessionBinding(w, r, session) {
+		return false
+	}
+
+	ok, err := verifyRefreshToken(refreshToken, GUID, session.TokenHash, session.TokenHasher)
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Default().Printf("error when trying to check access token hash: %v", err)
+		return false
+	}
+
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		log.Default().Printf("incorrecr refresh token hash")
+		w.Write([]byte("Incorrect hash"))
+		return false
+	}
+
+	return true
+}
+
+// rejectRotatedRefreshToken answers refresh with Refresh token of previous generation
+// When token is genuine it was already used, so whole session is revoked since token was stolen or replayed
+// Immediately previous token presented during refreshGracePeriod is retry of lost response, it gets already issued pair
+func rejectRotatedRefreshToken(w http.ResponseWriter, sessions store.SessionStore, session store.Session, GUID string, ip string, refreshToken api.SessionRefreshToken, mailer mail.Mailer, publisher events.Publisher) {
+	_, generation := refreshToken.Lookup()
+
+	if generation > session.Generation {
+		log.Default().Printf("attempted to refresh with Refresh token of unknown generation\n")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Incorrect hash"))
+		return
+	}
+
+	rotated, err := sessions.GetRotated(session.ID, generation)
+
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			log.Default().Printf("rotated Refresh token not found\n")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Incorrect hash"))
+		} else {
+			log.Default().Printf("error when trying to load rotated refresh token hash: %v\n", err)
 			w.WriteHeader(http.StatusInternalServerError)
-			return
 		}
+		return
+	}
 
-		if accessToken.Signature != accessTokenSignature {
-			log.Default().Printf("attempted to refresh with access token with incorrect signature: %v\n", err)
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("there is incorrect Refresh token in request"))
-			return
-		}
+	ok, err := verifyRefreshToken(refreshToken, GUID, rotated.TokenHash, rotated.TokenHasher)
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Default().Printf("error when trying to check rotated refresh token hash: %v\n", err)
+		return
+	}
+
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		log.Default().Printf("incorrecr refresh token hash")
+		w.Write([]byte("Incorrect hash"))
+		return
+	}
 
-		if refreshToken.Payload.AccessTokenSignature != accessTokenSignature {
-			log.Default().Printf("attempted to refresh with signature in refresh token not equal to signature of access token: %v\n", err)
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("there is incorrect Refresh token in request"))
+	now := clock.Now()
+
+	if generation == session.Generation-1 && now.Before(rotated.RotatedAt.Add(refreshGracePeriod)) {
+		response, ok := issuedPairs.Load(session.ID, generation, now)
+
+		if !ok {
+			// Pair was issued by another instance or cache was lost, session is kept since token is not stolen
+			log.Default().Printf("retried refresh within grace period, but issued pair is not cached\n")
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte("Refresh token was already used, use newly issued pair"))
 			return
 		}
 
-		requestHash, err := refreshToken.Hash(GUID)
+		log.Default().Printf("retried refresh within grace period, returning issued pair\n")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(response)
+		return
+	}
 
-		if err != nil {
-			log.Printf("error when calculating Refresh token hash: %v", err)
-			w.WriteHeader(http.StatusInternalServerError)
+	if err = sessions.Revoke(session.ID, now); err != nil {
+		log.Default().Println("failed to revoke session: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	details := fmt.Sprintf("Refresh token of generation %v was reused, current generation is %v", generation, session.Generation)
+
+	publisher.Publish(events.Event{
+		Type:    events.RefreshTokenReuse,
+		GUID:    session.GUID,
+		Session: session.ID,
+		Ip:      ip,
+		Time:    now,
+		Details: details,
+	})
+
+	// TODO add user email from DB
+	mailer.SendWarning("authwarning@example.com", "user@example.com", "warning session was revoked: "+details)
+
+	w.WriteHeader(http.StatusUnauthorized)
+	w.Write([]byte("Refresh token was already used, session is revoked"))
+}
+
+func newHandleRefresh(sessions store.SessionStore, mailer mail.Mailer, publisher events.Publisher) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		if err := validateAuthRequest(w, r); err != nil {
 			return
 		}
 
-		tx, err := DB.Begin()
+		GUID := r.Header.Get("Guid")
+		ip := r.RemoteAddr
 
-		if err != nil {
-			log.Printf("error starting transaction: %v", err)
-			w.WriteHeader(http.StatusInternalServerError)
+		accessToken, refreshToken, ok := readTokenPair(w, r, GUID)
+
+		if !ok {
 			return
 		}
 
-		defer func() {
-			if err != nil {
-				tx.Rollback()
-			}
-		}()
+		now := clock.Now()
 
-		session := p.AccessToken.Payload.Session
+		// Expiry inside Refresh token is not trusted, stored session expiry is checked instead
+		current, ok := loadActiveSession(w, sessions, accessToken.Payload.Session, now)
 
-		hash, err := GetSessionHash(tx, session)
+		if !ok {
+			return
+		}
 
-		if err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				log.Printf("Refresh token hash not found")
-				w.WriteHeader(http.StatusUnauthorized)
-				w.Write([]byte("session not found"))
-				return
-			} else {
-				log.Printf("error when trying to check refresh token hash in database: %v", err)
-				w.WriteHeader(http.StatusInternalServerError)
-				return
-			}
+		if !verifySessionBinding(w, r, current) {
+			return
+		}
 
+		if _, generation := refreshToken.Lookup(); generation != current.Generation {
+			rejectRotatedRefreshToken(w, sessions, current, GUID, ip, refreshToken, mailer, publisher)
+			return
 		}
 
-		ok, err := refreshToken.Verify(GUID, hash)
+		ok, err := verifyRefreshToken(refreshToken, GUID, current.TokenHash, current.TokenHasher)
 
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
-			log.Default().Printf("error when trying to check access token hash: %v", err)
+			log.Default().Printf("error when trying to check refresh token hash: %v", err)
 			return
 		}
 
@@ -145,7 +314,41 @@ func newHandleRefresh(DB *sql.DB, mailer mail.Mailer) func(w http.ResponseWriter
 			return
 		}
 
-		newAccessToken, newRefreshToken, err := generateAccessRefreshTokens(ip, session)
+		policy := sessionPolicy(current.ClientID)
+
+		if policy.Exceeded(current.CreatedAt, now) {
+			msg := "session lifetime exceeded"
+			log.Default().Printf(msg)
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(msg))
+			return
+		}
+
+		// Access token is issued together with Refresh token, so Access token of genuine pair
+		// can not have expired earlier than idle timeout before Refresh token
+		if now.After(accessToken.Header.Exp.Add(policy.IdleTimeout + tokenLeeway)) {
+			log.Default().Printf("attempted to refresh with Access token expired at: %v\n", accessToken.Header.Exp)
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Access token is too old"))
+			return
+		}
+
+		// Sessions created before client address was stored have it only in legacy token
+		lastIp := current.Ip
+
+		if legacyToken, isLegacy := refreshToken.(api.RefreshToken); lastIp == "" && isLegacy {
+			lastIp = legacyToken.Payload.Ip
+		}
+
+		// TODO add user email from DB
+		if lastIp != ip {
+			msg := fmt.Sprintf("warning attempting token refresh from another IP.\nOld ip: %v\nNew ip: %v", lastIp, ip)
+			mailer.SendWarning("authwarning@example.com", "user@example.com", msg)
+		}
+
+		expires := policy.Expires(current.CreatedAt, now)
+
+		newAccessToken, newRefreshToken, err := generateAccessRefreshTokens(ip, GUID, current.ID, current.Generation+1, expires, sessionConfirmation(current))
 
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
@@ -153,6 +356,14 @@ func newHandleRefresh(DB *sql.DB, mailer mail.Mailer) func(w http.ResponseWriter
 			return
 		}
 
+		newHash, newHasherID, err := hashRefreshToken(newRefreshToken, GUID)
+
+		if err != nil {
+			log.Printf("error when calculating Refresh token hash: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
 		tokenPair, err := makeTokenPair(newAccessToken, newRefreshToken)
 
 		if err != nil {
@@ -169,18 +380,42 @@ func newHandleRefresh(DB *sql.DB, mailer mail.Mailer) func(w http.ResponseWriter
 			return
 		}
 
-		if err = UpdateSession(tx, requestHash, session, newRefreshToken.Header.Expires); err != nil {
-			log.Default().Println("failed to update session: ", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+		userAgent, deviceName := clientMetadata(r)
+
+		// User-Agent and device name are kept when client does not send them on refresh
+		if userAgent == "" {
+			userAgent = current.UserAgent
+		}
+
+		if deviceName == "" {
+			deviceName = current.DeviceName
+		}
+
+		next := store.Session{
+			TokenHash:   newHash,
+			TokenHasher: newHasherID,
+			ExpiresAt:   expires,
+			Ip:          ip,
+			UserAgent:   userAgent,
+			DeviceName:  deviceName,
 		}
 
-		if err = tx.Commit(); err != nil {
-			log.Printf("error when committing transaction: %v", err)
+		if err = sessions.Rotate(current, next, now); err != nil {
+			if errors.Is(err, store.ErrConcurrentRotation) {
+				log.Default().Println("lost concurrent refresh: ", err)
+				w.WriteHeader(http.StatusConflict)
+				w.Write([]byte("session was refreshed concurrently"))
+				return
+			}
+			log.Default().Println("failed to rotate session: ", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
+		if refreshGracePeriod > 0 {
+			issuedPairs.Store(current.ID, current.Generation, answerJson, now.Add(refreshGracePeriod))
+		}
+
 		w.Header().Set("Content-Type", "application/json")
 		w.Write(answerJson)
 
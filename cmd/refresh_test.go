@@ -2,13 +2,21 @@ package main
 
 import (
 	api "authservice/pkg/api"
+	"authservice/pkg/auth"
+	"authservice/pkg/events"
 	"authservice/pkg/mail"
+	"authservice/pkg/store"
 	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -28,7 +36,12 @@ type testDataRefresh struct {
 	MustMail          bool
 	WrongSession      bool
 	Method            string
-	TokenExpired      bool
+	// TokenExpired expires session in store while expiry inside Refresh token stays valid
+	TokenExpired bool
+	// TamperExpiry extends expiry inside Refresh token passed by client
+	TamperExpiry  bool
+	LegacyFormat  bool
+	AsymmetricKey bool
 }
 
 func runRefreshTest(test testDataRefresh) error {
@@ -36,7 +49,41 @@ func runRefreshTest(test testDataRefresh) error {
 
 	session := ""
 
-	tokens, err := generateAccessRefreshPair(ip, session)
+	if test.LegacyFormat {
+		accessTokenFormat = AccessTokenFormatLegacy
+		defer func() { accessTokenFormat = AccessTokenFormatCompact }()
+	}
+
+	if test.AsymmetricKey {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+
+		if err != nil {
+			return err
+		}
+
+		signer, err := auth.NewSigner(key)
+
+		if err != nil {
+			return err
+		}
+
+		testingKeyring := keyring
+
+		if keyring, err = newTestingKeyring(signer); err != nil {
+			return err
+		}
+
+		defer func() { keyring = testingKeyring }()
+	}
+
+	var guid string
+	if len(test.GUID) > 0 {
+		guid = test.GUID[0]
+	} else {
+		guid = "guid"
+	}
+
+	tokens, err := generateAccessRefreshPair(ip, guid, session)
 
 	if test.WrongSession {
 		session = "*"
@@ -54,6 +101,22 @@ func runRefreshTest(test testDataRefresh) error {
 		tokens.AccessToken.Header.Exp = tokens.AccessToken.Header.Exp.Add(time.Hour)
 	}
 
+	issuedRefreshToken := tokens.RefreshToken
+
+	if test.TamperExpiry {
+		refreshToken, err := api.LoadRefreshTokenFromBase64(tokens.RefreshToken)
+
+		if err != nil {
+			return err
+		}
+
+		refreshToken.Header.Expires = refreshToken.Header.Expires.Add(time.Hour * 24 * 365)
+
+		if tokens.RefreshToken, err = refreshToken.Base64(); err != nil {
+			return err
+		}
+	}
+
 	requestBody, err := json.Marshal(tokens)
 
 	if err != nil {
@@ -63,13 +126,6 @@ func runRefreshTest(test testDataRefresh) error {
 	request, err := http.NewRequest(http.MethodPost, "/v1/auth", bytes.NewBuffer(requestBody))
 	request.RemoteAddr = ip
 
-	var guid string
-	if len(test.GUID) > 0 {
-		guid = test.GUID[0]
-	} else {
-		guid = "guid"
-	}
-
 	if !test.ChangeGuid {
 		request.Header["Guid"] = test.GUID
 	} else {
@@ -80,17 +136,17 @@ func runRefreshTest(test testDataRefresh) error {
 
 	var mailer mail.Mailer = &dummyMailer{}
 
-	DB, err := CreateTestingBD()
+	sessions, err := CreateTestingStore()
 	if err != nil {
 		return err
 	}
-	defer DB.Close()
+	defer sessions.Close()
 
 	if !test.DBHasNotToken {
 
 		hash := "wrong hash"
 
-		refreshToken, err := api.LoadRefreshTokenFromBase64(tokens.RefreshToken)
+		refreshToken, err := api.LoadRefreshTokenFromBase64(issuedRefreshToken)
 
 		if err != nil {
 			return err
@@ -106,13 +162,26 @@ func runRefreshTest(test testDataRefresh) error {
 
 		fmt.Println("used hash: ", hash)
 
-		err = AddSession(DB, hash, guid, session, refreshToken.Header.Expires)
+		expires := refreshToken.Header.Expires
+
+		if test.TokenExpired {
+			expires = clock.Now().Add(-time.Minute)
+		}
+
+		err = sessions.Create(store.Session{
+			ID:          session,
+			GUID:        guid,
+			TokenHash:   hash,
+			TokenHasher: api.BcryptHasherID,
+			ExpiresAt:   expires,
+			Ip:          "127.0.0.1",
+		})
 		if err != nil {
 			return err
 		}
 	}
 
-	handler := http.HandlerFunc(newHandleRefresh(DB, mailer))
+	handler := http.HandlerFunc(newHandleRefresh(sessions, mailer, &dummyPublisher{}))
 
 	recorder := httptest.NewRecorder()
 
@@ -162,6 +231,8 @@ func runRefreshTest(test testDataRefresh) error {
 }
 
 func TestRefresh(t *testing.T) {
+	now := testNow
+	fixClock(t, &now)
 
 	tests := []testDataRefresh{
 		testDataRefresh{
@@ -203,6 +274,46 @@ func TestRefresh(t *testing.T) {
 			WrongHash:    false,
 			WrongSession: true,
 			Method:       http.MethodPost,
+		}, testDataRefresh{
+			GUID:         []string{"hello"},
+			Name:         "Legacy format",
+			MustFail:     false,
+			WrongHash:    false,
+			Method:       http.MethodPost,
+			LegacyFormat: true,
+		}, testDataRefresh{
+			GUID:          []string{"hello"},
+			Name:          "Asymmetric signing key",
+			MustFail:      false,
+			WrongHash:     false,
+			Method:        http.MethodPost,
+			AsymmetricKey: true,
+		}, testDataRefresh{
+			GUID:       []string{"hello"},
+			Name:       "Another subject",
+			MustFail:   true,
+			WrongHash:  false,
+			Method:     http.MethodPost,
+			ChangeGuid: true,
+		}, testDataRefresh{
+			GUID:         []string{"hello"},
+			Name:         "Expired session",
+			MustFail:     true,
+			Method:       http.MethodPost,
+			TokenExpired: true,
+		}, testDataRefresh{
+			GUID:         []string{"hello"},
+			Name:         "Tampered expiry of expired session",
+			MustFail:     true,
+			Method:       http.MethodPost,
+			TokenExpired: true,
+			TamperExpiry: true,
+		}, testDataRefresh{
+			GUID:         []string{"hello"},
+			Name:         "Tampered expiry",
+			MustFail:     true,
+			Method:       http.MethodPost,
+			TamperExpiry: true,
 		},
 	}
 
@@ -230,3 +341,389 @@ func (m *dummyMailer) SendWarning(from, to string, msg string) {
 	fmt.Printf("new message from: %v, to: %v, content: %v", from, to, msg)
 	m.cnt++
 }
+
+type dummyPublisher struct {
+	events []events.Event
+}
+
+func (p *dummyPublisher) Publish(event events.Event) {
+	p.events = append(p.events, event)
+}
+
+// readTokenPairResponse unmarshalls token pair from successful response
+func readTokenPairResponse(t *testing.T, recorder *httptest.ResponseRecorder) api.RefreshAccessTokenPair {
+	t.Helper()
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Request failed with code: %v", recorder.Code)
+	}
+
+	var tokens api.RefreshAccessTokenPair
+
+	if err := json.Unmarshal(recorder.Body.Bytes(), &tokens); err != nil {
+		t.Fatalf("failed to unmarshall answer from server: %v", err)
+	}
+
+	return tokens
+}
+
+func TestRefreshRotation(t *testing.T) {
+	sessions, err := CreateTestingStore()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer sessions.Close()
+
+	publisher := &dummyPublisher{}
+	mailer := &dummyMailer{}
+
+	refresh := http.HandlerFunc(newHandleRefresh(sessions, mailer, publisher))
+
+	first := authenticate(t, sessions, "guid")
+
+	second := readTokenPairResponse(t, postTokenPair(t, refresh, "guid", first))
+
+	session, err := sessions.Get(first.AccessToken.Payload.Session)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refreshToken, err := api.LoadRefreshTokenFromBase64(second.RefreshToken)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := refreshToken.Verify("guid", session.TokenHash); err != nil || !ok {
+		t.Fatalf("session does not store hash of newly issued Refresh token: %v", err)
+	}
+
+	third := readTokenPairResponse(t, postTokenPair(t, refresh, "guid", second))
+
+	if len(publisher.events) != 0 {
+		t.Fatalf("security event raised for normal rotation: %#v", publisher.events)
+	}
+
+	if code := postTokenPair(t, refresh, "guid", first).Code; code != http.StatusUnauthorized {
+		t.Fatalf("reused Refresh token must fail with 401, got: %v", code)
+	}
+
+	if len(publisher.events) != 1 || publisher.events[0].Type != events.RefreshTokenReuse {
+		t.Fatalf("reuse security event was not raised: %#v", publisher.events)
+	}
+
+	if mailer.cnt == 0 {
+		t.Fatalf("reuse warning was not mailed")
+	}
+
+	if code := postTokenPair(t, refresh, "guid", third).Code; code != http.StatusUnauthorized {
+		t.Fatalf("session must be revoked after reuse, refresh got: %v", code)
+	}
+}
+
+func TestRefreshOpaque(t *testing.T) {
+	refreshTokenFormat = RefreshTokenFormatOpaque
+	defer func() { refreshTokenFormat = RefreshTokenFormatLegacy }()
+
+	sessions, err := CreateTestingStore()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer sessions.Close()
+
+	publisher := &dummyPublisher{}
+	mailer := &dummyMailer{}
+
+	refresh := http.HandlerFunc(newHandleRefresh(sessions, mailer, publisher))
+
+	first := authenticate(t, sessions, "guid")
+	session := first.AccessToken.Payload.Session
+
+	if _, err := api.LoadRefreshTokenFromBase64(first.RefreshToken); err == nil {
+		t.Fatalf("opaque Refresh token was loaded as base64 JSON: %v", first.RefreshToken)
+	}
+
+	if !strings.HasPrefix(first.RefreshToken, session+".0.") {
+		t.Fatalf("opaque Refresh token has no session lookup prefix: %v", first.RefreshToken)
+	}
+
+	stored, err := sessions.Get(session)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stored.Ip == "" {
+		t.Fatalf("client address was not stored in session")
+	}
+
+	code, response := introspect(t, sessions, url.Values{"token": {first.RefreshToken}, "token_type_hint": {TokenTypeHintRefreshToken}})
+
+	if code != http.StatusOK || !response.Active || response.Session != session {
+		t.Fatalf("opaque Refresh token is not active: %v %#v", code, response)
+	}
+
+	tampered := first
+	tampered.RefreshToken = first.RefreshToken[:len(first.RefreshToken)-2] + "AA"
+
+	if code := postTokenPair(t, refresh, "guid", tampered).Code; code != http.StatusUnauthorized {
+		t.Fatalf("tampered Refresh token must fail with 401, got: %v", code)
+	}
+
+	another := authenticate(t, sessions, "guid")
+	swapped := first
+	swapped.RefreshToken = another.RefreshToken
+
+	if code := postTokenPair(t, refresh, "guid", swapped).Code; code != http.StatusBadRequest {
+		t.Fatalf("Refresh token of another session must fail with 400, got: %v", code)
+	}
+
+	second := readTokenPairResponse(t, postTokenPair(t, refresh, "guid", first))
+
+	if !strings.HasPrefix(second.RefreshToken, session+".1.") {
+		t.Fatalf("rotated Refresh token has unexpected prefix: %v", second.RefreshToken)
+	}
+
+	if code := postTokenPair(t, refresh, "guid", first).Code; code != http.StatusUnauthorized {
+		t.Fatalf("reused Refresh token must fail with 401, got: %v", code)
+	}
+
+	if len(publisher.events) != 1 || publisher.events[0].Type != events.RefreshTokenReuse {
+		t.Fatalf("reuse security event was not raised: %#v", publisher.events)
+	}
+
+	if code := postTokenPair(t, refresh, "guid", second).Code; code != http.StatusUnauthorized {
+		t.Fatalf("session must be revoked after reuse, refresh got: %v", code)
+	}
+}
+
+func TestRefreshConcurrent(t *testing.T) {
+	sessions, err := CreateTestingStore()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer sessions.Close()
+
+	refresh := http.HandlerFunc(newHandleRefresh(sessions, &dummyMailer{}, &dummyPublisher{}))
+
+	tokens := authenticate(t, sessions, "guid")
+
+	requestBody, err := json.Marshal(tokens)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const parallel = 8
+
+	recorders := make([]*httptest.ResponseRecorder, parallel)
+	requests := make([]*http.Request, parallel)
+
+	for i := range requests {
+		requests[i], err = http.NewRequest(http.MethodPost, "/v1/refresh", bytes.NewReader(requestBody))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		requests[i].Header.Set("Guid", "guid")
+		requests[i].RemoteAddr = "127.0.0.1"
+		recorders[i] = httptest.NewRecorder()
+	}
+
+	start := make(chan struct{})
+	wg := sync.WaitGroup{}
+
+	for i := range requests {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			refresh.ServeHTTP(recorders[i], requests[i])
+		}(i)
+	}
+
+	close(start)
+	wg.Wait()
+
+	succeeded := 0
+
+	for _, recorder := range recorders {
+		switch recorder.Code {
+		case http.StatusOK:
+			succeeded++
+		case http.StatusConflict, http.StatusUnauthorized:
+		default:
+			t.Fatalf("unexpected status of concurrent refresh: %v", recorder.Code)
+		}
+	}
+
+	if succeeded != 1 {
+		t.Fatalf("%v of %v concurrent refreshes with same token succeeded, expected exactly one", succeeded, parallel)
+	}
+
+	session, err := sessions.Get(tokens.AccessToken.Payload.Session)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Stale session loaded before concurrent rotation must not be rotated again
+	err = sessions.Rotate(store.Session{ID: session.ID, Generation: 0}, store.Session{TokenHash: "hash", TokenHasher: api.BcryptHasherID}, clock.Now())
+
+	if !errors.Is(err, store.ErrConcurrentRotation) {
+		t.Fatalf("expected ErrConcurrentRotation when rotating stale session, got: %v", err)
+	}
+}
+
+func TestRefreshGracePeriod(t *testing.T) {
+	refreshGracePeriod = time.Minute
+	defer func() { refreshGracePeriod = 0 }()
+
+	sessions, err := CreateTestingStore()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer sessions.Close()
+
+	publisher := &dummyPublisher{}
+
+	refresh := http.HandlerFunc(newHandleRefresh(sessions, &dummyMailer{}, publisher))
+
+	first := authenticate(t, sessions, "guid")
+	session := first.AccessToken.Payload.Session
+
+	issued := postTokenPair(t, refresh, "guid", first)
+
+	if issued.Code != http.StatusOK {
+		t.Fatalf("refresh failed with code: %v", issued.Code)
+	}
+
+	second := readTokenPairResponse(t, issued)
+
+	retried := postTokenPair(t, refresh, "guid", first)
+
+	if retried.Code != http.StatusOK {
+		t.Fatalf("retried refresh within grace period failed with code: %v", retried.Code)
+	}
+
+	if !bytes.Equal(retried.Body.Bytes(), issued.Body.Bytes()) {
+		t.Fatalf("retried refresh returned another pair")
+	}
+
+	if len(publisher.events) != 0 {
+		t.Fatalf("security event raised for retried refresh: %#v", publisher.events)
+	}
+
+	// Pair issued by another instance is not cached
+	issuedPairs = newIssuedPairCache()
+
+	if code := postTokenPair(t, refresh, "guid", first).Code; code != http.StatusConflict {
+		t.Fatalf("retry without cached pair must fail with 409, got: %v", code)
+	}
+
+	stored, err := sessions.Get(session)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stored.Revoked() {
+		t.Fatalf("session was revoked by retry within grace period")
+	}
+
+	readTokenPairResponse(t, postTokenPair(t, refresh, "guid", second))
+
+	// First token is not immediately previous anymore
+	if code := postTokenPair(t, refresh, "guid", first).Code; code != http.StatusUnauthorized {
+		t.Fatalf("reused Refresh token must fail with 401, got: %v", code)
+	}
+
+	if len(publisher.events) != 1 || publisher.events[0].Type != events.RefreshTokenReuse {
+		t.Fatalf("reuse security event was not raised: %#v", publisher.events)
+	}
+}
+
+func TestRefreshClock(t *testing.T) {
+	now := testNow
+	fixClock(t, &now)
+
+	defer func() { tokenLeeway = 0 }()
+
+	sessions, err := CreateTestingStore()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer sessions.Close()
+
+	refresh := http.HandlerFunc(newHandleRefresh(sessions, &dummyMailer{}, &dummyPublisher{}))
+
+	first := authenticate(t, sessions, "guid")
+
+	// Clock of this instance is behind clock of instance which issued tokens
+	now = now.Add(-30 * time.Second)
+
+	if code := postTokenPair(t, refresh, "guid", first).Code; code != http.StatusUnauthorized {
+		t.Fatalf("Access token issued in future must fail with 401, got: %v", code)
+	}
+
+	tokenLeeway = time.Minute
+
+	second := readTokenPairResponse(t, postTokenPair(t, refresh, "guid", first))
+
+	tokenLeeway = 0
+
+	// Expired Access token is refreshed while session is active
+	now = now.Add(AccessTokenDuration + time.Hour)
+
+	if err := accessTokenVerifier().Verify(second.AccessToken); !errors.Is(err, auth.ErrTokenExpired) {
+		t.Fatalf("expected Access token to be expired, got: %v", err)
+	}
+
+	third := readTokenPairResponse(t, postTokenPair(t, refresh, "guid", second))
+
+	now = now.Add(RefreshTokenDuration + time.Hour)
+
+	if code := postTokenPair(t, refresh, "guid", third).Code; code != http.StatusUnauthorized {
+		t.Fatalf("refresh of expired session must fail with 401, got: %v", code)
+	}
+}
+
+func TestRefreshAccessTokenAge(t *testing.T) {
+	now := testNow
+	fixClock(t, &now)
+
+	sessions, err := CreateTestingStore()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer sessions.Close()
+
+	refresh := http.HandlerFunc(newHandleRefresh(sessions, &dummyMailer{}, &dummyPublisher{}))
+
+	pair := authenticate(t, sessions, "guid")
+
+	// Session is kept active, but Access token expired earlier than any Refresh token of its pair could live
+	now = now.Add(AccessTokenDuration + defaultSessionPolicy.IdleTimeout + time.Minute)
+
+	if _, err := sessions.DB().Exec("UPDATE sessions SET expires_at = $1 WHERE session_id = $2", now.Add(time.Hour), pair.AccessToken.Payload.Session); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := postTokenPair(t, refresh, "guid", pair).Code; code != http.StatusUnauthorized {
+		t.Fatalf("refresh with too old Access token must fail with 401, got: %v", code)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"authservice/pkg/auth"
+	"authservice/pkg/store"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+	"unicode/utf8"
+)
+
+// DeviceNameHeader carries optional device name given by client on /v1/auth and /v1/refresh
+const DeviceNameHeader string = "Device-Name"
+
+// Limits of client metadata stored with session, longer values are truncated
+const (
+	MaxUserAgentLength  int = 256
+	MaxDeviceNameLength int = 64
+)
+
+// SessionInfo describes session in /v1/sessions answer
+type SessionInfo struct {
+	ID              string     `json:"id"`
+	Current         bool       `json:"current"`
+	CreatedAt       *time.Time `json:"created_at,omitempty"`
+	LastRefreshedAt *time.Time `json:"last_refreshed_at,omitempty"`
+	ExpiresAt       time.Time  `json:"expires_at"`
+	Ip              string     `json:"ip,omitempty"`
+	UserAgent       string     `json:"user_agent,omitempty"`
+	DeviceName      string     `json:"device_name,omitempty"`
+	ClientID        string     `json:"client_id,omitempty"`
+}
+
+// SessionsResponse is answer of /v1/sessions
+type SessionsResponse struct {
+	Sessions []SessionInfo `json:"sessions"`
+}
+
+// truncate cuts s to at most limit bytes without splitting UTF-8 characters
+func truncate(s string, limit int) string {
+	if len(s) <= limit {
+		return s
+	}
+
+	s = s[:limit]
+
+	for len(s) > 0 && !utf8.ValidString(s) {
+		s = s[:len(s)-1]
+	}
+
+	return s
+}
+
+// clientMetadata returns User-Agent and device name of request to be stored with session
+func clientMetadata(r *http.Request) (userAgent string, deviceName string) {
+	return truncate(r.UserAgent(), MaxUserAgentLength), truncate(r.Header.Get(DeviceNameHeader), MaxDeviceNameLength)
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// callerSession loads active session of Access token placed into request context by middleware
+// On failure it writes response and returns false
+func callerSession(w http.ResponseWriter, r *http.Request, sessions store.SessionStore) (store.Session, bool) {
+	id, _ := auth.SessionFromContext(r.Context())
+	subject, _ := auth.SubjectFromContext(r.Context())
+
+	session, ok, err := activeSession(sessions, id)
+
+	if err != nil {
+		log.Default().Printf("error when trying to load caller session: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return store.Session{}, false
+	}
+
+	// Access tokens outlive sessions they were issued for, revoked session must not manage others
+	if !ok || session.GUID != subject {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token", error_description="session is not active"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("session is not active"))
+		return store.Session{}, false
+	}
+
+	return session, true
+}
+
+// newHandleListSessions lists active sessions of user authenticated by Access token
+func newHandleListSessions(sessions store.SessionStore) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current, ok := callerSession(w, r, sessions)
+
+		if !ok {
+			return
+		}
+
+		active, err := sessions.List(current.GUID, clock.Now())
+
+		if err != nil {
+			log.Default().Printf("error when trying to list sessions: %v\n", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		response := SessionsResponse{Sessions: make([]SessionInfo, 0, len(active))}
+
+		for _, session := range active {
+			response.Sessions = append(response.Sessions, SessionInfo{
+				ID:              session.ID,
+				Current:         session.ID == current.ID,
+				CreatedAt:       optionalTime(session.CreatedAt),
+				LastRefreshedAt: optionalTime(session.LastRefreshedAt),
+				ExpiresAt:       session.ExpiresAt,
+				Ip:              session.Ip,
+				UserAgent:       session.UserAgent,
+				DeviceName:      session.DeviceName,
+				ClientID:        session.ClientID,
+			})
+		}
+
+		answerJson, err := json.Marshal(response)
+
+		if err != nil {
+			log.Default().Println("sessions answer json marshalling error: ", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(answerJson)
+	})
+}
+
+// newHandleRevokeSession revokes session with id from path if it belongs to user authenticated by Access token
+func newHandleRevokeSession(sessions store.SessionStore) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current, ok := callerSession(w, r, sessions)
+
+		if !ok {
+			return
+		}
+
+		session, err := sessions.Get(r.PathValue("id"))
+
+		// Sessions of other users are reported as missing, so their ids can not be probed
+		if errors.Is(err, store.ErrNotFound) || (err == nil && session.GUID != current.GUID) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("session not found"))
+			return
+		}
+
+		if err != nil {
+			log.Default().Printf("error when trying to load session: %v\n", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if err = sessions.Revoke(session.ID, clock.Now()); err != nil {
+			log.Default().Println("failed to revoke session: ", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
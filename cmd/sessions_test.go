@@ -0,0 +1,188 @@
+package main
+
+import (
+	api "authservice/pkg/api"
+	"authservice/pkg/store"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// authenticateDevice authenticates guid with User-Agent and device name headers
+func authenticateDevice(t *testing.T, sessions store.SessionStore, guid string, userAgent string, deviceName string) api.RefreshAccessTokenPair {
+	t.Helper()
+
+	request, err := http.NewRequest(http.MethodPost, "/v1/auth", strings.NewReader(""))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request.Header.Add("Guid", guid)
+	request.Header.Set("User-Agent", userAgent)
+	request.Header.Set(DeviceNameHeader, deviceName)
+	request.RemoteAddr = "127.0.0.1"
+
+	recorder := httptest.NewRecorder()
+
+	http.HandlerFunc(newHandleAuth(sessions, &dummyMailer{}, &dummyPublisher{})).ServeHTTP(recorder, request)
+
+	return readTokenPairResponse(t, recorder)
+}
+
+func newSessionsMux(sessions store.SessionStore) *http.ServeMux {
+	verifier := accessTokenVerifier()
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /v1/sessions", verifier.Middleware(newHandleListSessions(sessions)))
+	mux.Handle("DELETE /v1/sessions/{id}", verifier.Middleware(newHandleRevokeSession(sessions)))
+
+	return mux
+}
+
+func callSessions(t *testing.T, mux *http.ServeMux, method string, path string, tokens api.RefreshAccessTokenPair) *httptest.ResponseRecorder {
+	t.Helper()
+
+	request, err := http.NewRequest(method, path, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request.Header.Set("Authorization", "Bearer "+tokens.AccessToken.Raw)
+
+	recorder := httptest.NewRecorder()
+
+	mux.ServeHTTP(recorder, request)
+
+	return recorder
+}
+
+func listSessions(t *testing.T, mux *http.ServeMux, tokens api.RefreshAccessTokenPair) []SessionInfo {
+	t.Helper()
+
+	recorder := callSessions(t, mux, http.MethodGet, "/v1/sessions", tokens)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("listing sessions failed with code: %v", recorder.Code)
+	}
+
+	var result SessionsResponse
+
+	if err := json.Unmarshal(recorder.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+
+	return result.Sessions
+}
+
+func TestListSessions(t *testing.T) {
+	sessions, err := CreateTestingStore()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer sessions.Close()
+
+	laptop := authenticateDevice(t, sessions, "guid", "curl/8.0", "laptop")
+	phone := authenticateDevice(t, sessions, "guid", "okhttp/4.12", "phone")
+	authenticate(t, sessions, "another guid")
+
+	mux := newSessionsMux(sessions)
+
+	result := listSessions(t, mux, laptop)
+
+	if len(result) != 2 {
+		t.Fatalf("expected two sessions of user, got: %v", len(result))
+	}
+
+	current := 0
+
+	for _, session := range result {
+		if session.Current {
+			current++
+
+			if session.UserAgent != "curl/8.0" || session.DeviceName != "laptop" {
+				t.Fatalf("unexpected metadata of current session: %+v", session)
+			}
+		}
+
+		if session.Ip != "127.0.0.1" || session.CreatedAt == nil || session.LastRefreshedAt != nil {
+			t.Fatalf("unexpected session: %+v", session)
+		}
+	}
+
+	if current != 1 {
+		t.Fatalf("expected one current session, got: %v", current)
+	}
+
+	refresh := http.HandlerFunc(newHandleRefresh(sessions, &dummyMailer{}, &dummyPublisher{}))
+
+	if code := postTokenPair(t, refresh, "guid", phone).Code; code != http.StatusOK {
+		t.Fatalf("refresh failed with code: %v", code)
+	}
+
+	for _, session := range listSessions(t, mux, laptop) {
+		if session.DeviceName != "phone" {
+			continue
+		}
+
+		// Refresh request carries neither User-Agent nor device name, so previous ones are kept
+		if session.LastRefreshedAt == nil || session.UserAgent != "okhttp/4.12" {
+			t.Fatalf("refresh did not update session metadata: %+v", session)
+		}
+	}
+
+	if code := callSessions(t, mux, http.MethodGet, "/v1/sessions", api.RefreshAccessTokenPair{}).Code; code != http.StatusUnauthorized {
+		t.Fatalf("listing sessions without Access token must fail with 401, got: %v", code)
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	sessions, err := CreateTestingStore()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer sessions.Close()
+
+	current := authenticateDevice(t, sessions, "guid", "curl/8.0", "laptop")
+	other := authenticateDevice(t, sessions, "guid", "okhttp/4.12", "phone")
+	anotherUser := authenticate(t, sessions, "another guid")
+
+	mux := newSessionsMux(sessions)
+
+	if code := callSessions(t, mux, http.MethodDelete, "/v1/sessions/"+anotherUser.AccessToken.Payload.Session, current).Code; code != http.StatusNotFound {
+		t.Fatalf("revoking session of another user must fail with 404, got: %v", code)
+	}
+
+	if code := callSessions(t, mux, http.MethodDelete, "/v1/sessions/unknown", current).Code; code != http.StatusNotFound {
+		t.Fatalf("revoking unknown session must fail with 404, got: %v", code)
+	}
+
+	if code := callSessions(t, mux, http.MethodDelete, "/v1/sessions/"+other.AccessToken.Payload.Session, current).Code; code != http.StatusNoContent {
+		t.Fatalf("revoking session failed with code: %v", code)
+	}
+
+	if result := listSessions(t, mux, current); len(result) != 1 || !result[0].Current {
+		t.Fatalf("expected only current session to remain, got: %+v", result)
+	}
+
+	// Access token of revoked session is still valid, but must not manage sessions
+	if code := callSessions(t, mux, http.MethodGet, "/v1/sessions", other).Code; code != http.StatusUnauthorized {
+		t.Fatalf("listing sessions with revoked session must fail with 401, got: %v", code)
+	}
+
+	if _, err := sessions.Get(anotherUser.AccessToken.Payload.Session); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := postTokenPair(t, http.HandlerFunc(newHandleRefresh(sessions, &dummyMailer{}, &dummyPublisher{})), "another guid", anotherUser).Code; code != http.StatusOK {
+		t.Fatalf("session of another user was revoked, refresh failed with code: %v", code)
+	}
+
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	api "authservice/pkg/api"
+	"authservice/pkg/auth"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestCertificate returns self signed certificate with PEM encoding of it and its key
+func newTestCertificate(t *testing.T, name string) (*x509.Certificate, []byte, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+
+	return cert, certPem, keyPem
+}
+
+// postWithCertificate sends request to handler on behalf of guid over connection authenticated with cert unless it is nil
+func postWithCertificate(t *testing.T, handler http.HandlerFunc, guid string, tokens *api.RefreshAccessTokenPair, cert *x509.Certificate) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var requestBody []byte
+
+	if tokens != nil {
+		var err error
+
+		if requestBody, err = json.Marshal(tokens); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/", bytes.NewBuffer(requestBody))
+	request.Header.Set("Guid", guid)
+	request.RemoteAddr = "127.0.0.1"
+	request.TLS = &tls.ConnectionState{}
+
+	if cert != nil {
+		request.TLS.PeerCertificates = []*x509.Certificate{cert}
+	}
+
+	recorder := httptest.NewRecorder()
+
+	handler.ServeHTTP(recorder, request)
+
+	return recorder
+}
+
+func TestCertificateBoundTokens(t *testing.T) {
+	sessions, err := CreateTestingStore()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer sessions.Close()
+
+	authHandler := http.HandlerFunc(newHandleAuth(sessions, &dummyMailer{}, &dummyPublisher{}))
+	refresh := http.HandlerFunc(newHandleRefresh(sessions, &dummyMailer{}, &dummyPublisher{}))
+
+	cert, _, _ := newTestCertificate(t, "client")
+	another, _, _ := newTestCertificate(t, "another")
+	thumbprint := auth.CertificateThumbprint(cert)
+
+	first := readTokenPairResponse(t, postWithCertificate(t, authHandler, "guid", nil, cert))
+
+	if first.TokenType != api.TokenTypeBearer {
+		t.Fatalf("unexpected token type: %v", first.TokenType)
+	}
+
+	if first.AccessToken.Payload.Confirmation == nil || first.AccessToken.Payload.Confirmation.X5TS256 != thumbprint {
+		t.Fatalf("Access token is not bound to client certificate: %#v", first.AccessToken.Payload.Confirmation)
+	}
+
+	stored, err := sessions.Get(first.AccessToken.Payload.Session)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stored.CertThumbprint != thumbprint {
+		t.Fatalf("session is not bound to client certificate: %v", stored.CertThumbprint)
+	}
+
+	if code := postWithCertificate(t, refresh, "guid", &first, another).Code; code != http.StatusUnauthorized {
+		t.Fatalf("refresh with another certificate must fail with 401, got: %v", code)
+	}
+
+	if code := postWithCertificate(t, refresh, "guid", &first, nil).Code; code != http.StatusUnauthorized {
+		t.Fatalf("refresh without certificate must fail with 401, got: %v", code)
+	}
+
+	second := readTokenPairResponse(t, postWithCertificate(t, refresh, "guid", &first, cert))
+
+	if second.AccessToken.Payload.Confirmation == nil || second.AccessToken.Payload.Confirmation.X5TS256 != thumbprint {
+		t.Fatalf("refreshed Access token is not bound to client certificate: %#v", second.AccessToken.Payload.Confirmation)
+	}
+
+	protected := accessTokenVerifier().Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, test := range []struct {
+		Cert *x509.Certificate
+		Code int
+	}{{cert, http.StatusOK}, {another, http.StatusUnauthorized}} {
+		request := httptest.NewRequest(http.MethodGet, "/api", nil)
+		request.Header.Set("Authorization", "Bearer "+second.AccessToken.Raw)
+		request.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{test.Cert}}
+
+		recorder := httptest.NewRecorder()
+		protected.ServeHTTP(recorder, request)
+
+		if recorder.Code != test.Code {
+			t.Fatalf("expected status %v for certificate %v, got %v", test.Code, test.Cert.Subject.CommonName, recorder.Code)
+		}
+	}
+
+	for _, logout := range []http.HandlerFunc{newHandleLogout(sessions), newHandleLogoutAll(sessions)} {
+		if code := postWithCertificate(t, logout, "guid", &second, another).Code; code != http.StatusUnauthorized {
+			t.Fatalf("logout with another certificate must fail with 401, got: %v", code)
+		}
+
+		if code := postWithCertificate(t, logout, "guid", &second, nil).Code; code != http.StatusUnauthorized {
+			t.Fatalf("logout without certificate must fail with 401, got: %v", code)
+		}
+	}
+
+	if code := postWithCertificate(t, newHandleLogout(sessions), "guid", &second, cert).Code; code != http.StatusNoContent {
+		t.Fatalf("logout with session certificate failed: %v", code)
+	}
+
+	bearer := readTokenPairResponse(t, postWithCertificate(t, authHandler, "guid", nil, nil))
+
+	if bearer.AccessToken.Payload.Confirmation != nil {
+		t.Fatalf("Access token issued without client certificate is bound: %#v", bearer.AccessToken.Payload.Confirmation)
+	}
+}
+
+func TestLoadTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+
+	_, certPem, keyPem := newTestCertificate(t, "server")
+	_, caPem, _ := newTestCertificate(t, "client ca")
+
+	files := map[string][]byte{"cert.pem": certPem, "key.pem": keyPem, "ca.pem": caPem, "empty.pem": []byte("")}
+
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), content, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		Name       string
+		Cert       string
+		Key        string
+		ClientCA   string
+		MustFail   bool
+		TLS        bool
+		ClientAuth tls.ClientAuthType
+	}{
+		{Name: "Plain HTTP"},
+		{Name: "TLS", Cert: "cert.pem", Key: "key.pem", TLS: true, ClientAuth: tls.NoClientCert},
+		{Name: "Mutual TLS", Cert: "cert.pem", Key: "key.pem", ClientCA: "ca.pem", TLS: true, ClientAuth: tls.VerifyClientCertIfGiven},
+		{Name: "Client CA without TLS", ClientCA: "ca.pem", MustFail: true},
+		{Name: "No key", Cert: "cert.pem", MustFail: true},
+		{Name: "Empty client CA", Cert: "cert.pem", Key: "key.pem", ClientCA: "empty.pem", MustFail: true},
+	}
+
+	path := func(name string) string {
+		if name == "" {
+			return ""
+		}
+		return filepath.Join(dir, name)
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			t.Setenv("TLS_CERT_FILE", path(test.Cert))
+			t.Setenv("TLS_KEY_FILE", path(test.Key))
+			t.Setenv("TLS_CLIENT_CA_FILE", path(test.ClientCA))
+
+			config, err := loadTLSConfig()
+
+			if test.MustFail {
+				if err == nil {
+					t.Fatalf("expected error")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("failed to load TLS config: %v", err)
+			}
+
+			if (config != nil) != test.TLS {
+				t.Fatalf("unexpected TLS config: %#v", config)
+			}
+
+			if config != nil && config.ClientAuth != test.ClientAuth {
+				t.Fatalf("unexpected client authentication: %v", config.ClientAuth)
+			}
+		})
+	}
+}
@@ -3,22 +3,43 @@ package tokens
 import "time"
 
 // AccessToken is JWT token
+// When Raw is set token is transferred in RFC 7519 compact serialization,
+// otherwise it is transferred as legacy JSON object
 type AccessToken struct {
 	Signature string             `json:"signature"`
 	Payload   AccessTokenPayload `json:"payload"`
 	Header    AccessTokenHeader  `json:"header"`
+	Raw       string             `json:"-"`
 }
 
 // AccessTokenPayload is payload for AccessToken
-// Currently empty
+// Besides session it carries RFC 7519 registered claims, time claims are NumericDate values
 type AccessTokenPayload struct {
-	Session string `json:"session"`
+	Session   string `json:"session"`
+	Subject   string `json:"sub,omitempty"`
+	Issuer    string `json:"iss,omitempty"`
+	Audience  string `json:"aud,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	NotBefore int64  `json:"nbf,omitempty"`
+	ID        string `json:"jti,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	// Confirmation binds token to key of client, tokens without it are bearer tokens
+	Confirmation *Confirmation `json:"cnf,omitempty"`
+}
+
+// Confirmation is RFC 7800 "cnf" claim
+type Confirmation struct {
+	// JKT is RFC 7638 thumbprint of DPoP proof key as defined by RFC 9449
+	JKT string `json:"jkt,omitempty"`
+	// X5TS256 is SHA-256 thumbprint of client certificate as defined by RFC 8705
+	X5TS256 string `json:"x5t#S256,omitempty"`
 }
 
 // Header for AccessToken
 type AccessTokenHeader struct {
 	Type string    `json:"typ"`
 	Alg  string    `json:"alg"`
+	Kid  string    `json:"kid,omitempty"`
 	Exp  time.Time `json:"exp"`
 }
 
@@ -27,7 +48,7 @@ func NewAccessToken(Exp time.Time, session string) AccessToken {
 	return AccessToken{
 		Header: AccessTokenHeader{
 			Type: "JWT",
-			Alg:  "SHA512",
+			Alg:  "HS512",
 			Exp:  Exp,
 		},
 		Payload: AccessTokenPayload{
@@ -14,7 +14,7 @@ func TestNewAccessTokenCreation(t *testing.T) {
 	if token.Header.Type != "JWT" {
 		t.Errorf("expected Header.Type to be 'JWT', Header.Type is '%s'", token.Header.Type)
 	}
-	if token.Header.Alg != "SHA512" {
-		t.Errorf("expected Header.Alg to be 'SHA512', Header.Alg is '%s'", token.Header.Alg)
+	if token.Header.Alg != "HS512" {
+		t.Errorf("expected Header.Alg to be 'HS512', Header.Alg is '%s'", token.Header.Alg)
 	}
 }
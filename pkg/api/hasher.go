@@ -0,0 +1,169 @@
+package tokens
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Token hasher IDs stored with each hash
+const (
+	BcryptHasherID     string = "bcrypt"
+	HMACSHA256HasherID string = "hmac-sha256"
+)
+
+// MinPepperLength is minimal length of HMACHasher pepper
+const MinPepperLength int = 32
+
+var ErrUnknownHasher error = errors.New("unknown Refresh token hasher")
+var ErrShortPepper error = errors.New("Refresh token hash pepper is too short")
+
+// TokenHasher calculates hashes of Refresh tokens stored in sessions
+type TokenHasher interface {
+	// ID is stored with hash to select hasher verifying it
+	ID() string
+	Hash(digest []byte) (string, error)
+	Verify(digest []byte, hash string) (bool, error)
+}
+
+// BcryptHasher hashes tokens with bcrypt, zero Cost means bcrypt.DefaultCost
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h BcryptHasher) ID() string {
+	return BcryptHasherID
+}
+
+// Hash computes base64 encoded bcrypt hash, digest must not be longer than 72 bytes
+func (h BcryptHasher) Hash(digest []byte) (string, error) {
+	cost := h.Cost
+
+	if cost == 0 {
+		cost = bcrypt.DefaultCost
+	}
+
+	hash, err := bcrypt.GenerateFromPassword(digest, cost)
+
+	if err != nil {
+		return "", fmt.Errorf("failed to calculate bcrypt hash for refresh token: %w", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(hash), nil
+}
+
+func (h BcryptHasher) Verify(digest []byte, hash string) (bool, error) {
+	decodedHash, err := base64.StdEncoding.DecodeString(hash)
+
+	// Malformed stored hash matches no token
+	if err != nil {
+		return false, nil
+	}
+
+	err = bcrypt.CompareHashAndPassword(decodedHash, digest)
+
+	if err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// HMACHasher hashes tokens with HMAC-SHA256 keyed by server pepper
+// Refresh tokens are high-entropy, so slow hashing gives nothing while stored hashes are useless without pepper
+type HMACHasher struct {
+	pepper []byte
+}
+
+// NewHMACHasher creates HMACHasher, pepper must be at least MinPepperLength bytes
+func NewHMACHasher(pepper []byte) (*HMACHasher, error) {
+	if len(pepper) < MinPepperLength {
+		return nil, fmt.Errorf("%w: %v, expected at least: %v bytes", ErrShortPepper, len(pepper), MinPepperLength)
+	}
+
+	return &HMACHasher{pepper: pepper}, nil
+}
+
+func (h *HMACHasher) ID() string {
+	return HMACSHA256HasherID
+}
+
+func (h *HMACHasher) sum(digest []byte) []byte {
+	mac := hmac.New(sha256.New, h.pepper)
+	mac.Write(digest)
+	return mac.Sum(nil)
+}
+
+// Hash computes base64 encoded HMAC-SHA256 of digest
+func (h *HMACHasher) Hash(digest []byte) (string, error) {
+	return base64.StdEncoding.EncodeToString(h.sum(digest)), nil
+}
+
+// Verify compares hashes in constant time
+func (h *HMACHasher) Verify(digest []byte, hash string) (bool, error) {
+	decodedHash, err := base64.StdEncoding.DecodeString(hash)
+
+	if err != nil {
+		return false, nil
+	}
+
+	return hmac.Equal(h.sum(digest), decodedHash), nil
+}
+
+// TokenHashers selects hasher by ID stored with hash
+type TokenHashers map[string]TokenHasher
+
+// NewTokenHashers creates TokenHashers containing passed hashers
+func NewTokenHashers(hashers ...TokenHasher) TokenHashers {
+	result := TokenHashers{}
+
+	for _, hasher := range hashers {
+		result[hasher.ID()] = hasher
+	}
+
+	return result
+}
+
+// Get returns hasher by ID, empty ID refers to bcrypt since hashes stored before hasher ID was introduced are bcrypt
+func (h TokenHashers) Get(id string) (TokenHasher, error) {
+	if id == "" {
+		id = BcryptHasherID
+	}
+
+	hasher, ok := h[id]
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrUnknownHasher, id)
+	}
+
+	return hasher, nil
+}
+
+// HashToken computes hash of Refresh token bound to GUID
+func HashToken(hasher TokenHasher, token SessionRefreshToken, guid string) (string, error) {
+	digest, err := token.Digest(guid)
+
+	if err != nil {
+		return "", err
+	}
+
+	return hasher.Hash(digest)
+}
+
+// VerifyToken checks if the provided hash matches the hash of Refresh token bound to GUID
+func VerifyToken(hasher TokenHasher, token SessionRefreshToken, guid string, hash string) (bool, error) {
+	digest, err := token.Digest(guid)
+
+	if err != nil {
+		return false, err
+	}
+
+	return hasher.Verify(digest, hash)
+}
@@ -0,0 +1,150 @@
+package tokens
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var testingPepper []byte = []byte("testing pepper which is long enough")
+
+func TestTokenHashers(t *testing.T) {
+	hmacHasher, err := NewHMACHasher(testingPepper)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hashers := NewTokenHashers(BcryptHasher{Cost: bcrypt.MinCost}, hmacHasher)
+
+	token, err := NewOpaqueRefreshToken("session", 0)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	another, err := NewOpaqueRefreshToken("session", 0)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{BcryptHasherID, HMACSHA256HasherID} {
+		hasher, err := hashers.Get(id)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if hasher.ID() != id {
+			t.Fatalf("hasher %v returned for id %v", hasher.ID(), id)
+		}
+
+		hash, err := HashToken(hasher, token, "guid")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ok, err := VerifyToken(hasher, token, "guid", hash); err != nil || !ok {
+			t.Fatalf("%v failed to verify token: %v", id, err)
+		}
+
+		if ok, _ := VerifyToken(hasher, token, "another guid", hash); ok {
+			t.Fatalf("%v verified token with another GUID", id)
+		}
+
+		if ok, _ := VerifyToken(hasher, another, "guid", hash); ok {
+			t.Fatalf("%v verified another token", id)
+		}
+
+		if ok, err := VerifyToken(hasher, token, "guid", "not base64 hash!"); err != nil || ok {
+			t.Fatalf("%v verified token against malformed hash: %v", id, err)
+		}
+	}
+
+	legacyHash, err := token.Hash("guid")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hasher, err := hashers.Get("")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := VerifyToken(hasher, token, "guid", legacyHash); err != nil || !ok {
+		t.Fatalf("hash stored without hasher id was not verified: %v", err)
+	}
+
+	anotherPepper, err := NewHMACHasher([]byte("another testing pepper long enough"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash, err := HashToken(hmacHasher, token, "guid")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, _ := VerifyToken(anotherPepper, token, "guid", hash); ok {
+		t.Fatalf("hash was verified with another pepper")
+	}
+
+	if _, err := hashers.Get("md5"); !errors.Is(err, ErrUnknownHasher) {
+		t.Fatalf("expected ErrUnknownHasher, got: %v", err)
+	}
+
+	if _, err := NewHMACHasher([]byte("short")); !errors.Is(err, ErrShortPepper) {
+		t.Fatalf("expected ErrShortPepper, got: %v", err)
+	}
+}
+
+func benchmarkHasher(b *testing.B, hasher TokenHasher) {
+	token, err := NewOpaqueRefreshToken("session", 0)
+
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	hash, err := HashToken(hasher, token, "guid")
+
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("Hash", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := HashToken(hasher, token, "guid"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("Verify", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if ok, err := VerifyToken(hasher, token, "guid", hash); err != nil || !ok {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkBcryptHasher(b *testing.B) {
+	benchmarkHasher(b, BcryptHasher{})
+}
+
+func BenchmarkHMACHasher(b *testing.B) {
+	hasher, err := NewHMACHasher(testingPepper)
+
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	benchmarkHasher(b, hasher)
+}
@@ -0,0 +1,167 @@
+package tokens
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var ErrMalformedCompactToken error = errors.New("malformed compact Access token")
+
+// compactHeader is JOSE header of AccessToken in compact serialization
+type compactHeader struct {
+	Type string `json:"typ"`
+	Alg  string `json:"alg"`
+	Kid  string `json:"kid,omitempty"`
+}
+
+// compactClaims is JWT claims set of AccessToken in compact serialization
+// Expiration time is moved from header to registered "exp" claim as NumericDate
+type compactClaims struct {
+	AccessTokenPayload
+	Exp int64 `json:"exp"`
+}
+
+// SigningInput returns base64url(header) + "." + base64url(claims) of AccessToken as defined by RFC 7515
+func (t AccessToken) SigningInput() (string, error) {
+	headerJson, err := json.Marshal(compactHeader{
+		Type: t.Header.Type,
+		Alg:  t.Header.Alg,
+		Kid:  t.Header.Kid,
+	})
+
+	if err != nil {
+		return "", fmt.Errorf("failed to marshall Access token header: %w", err)
+	}
+
+	claimsJson, err := json.Marshal(compactClaims{
+		AccessTokenPayload: t.Payload,
+		Exp:                t.Header.Exp.Unix(),
+	})
+
+	if err != nil {
+		return "", fmt.Errorf("failed to marshall Access token claims: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(headerJson) + "." + base64.RawURLEncoding.EncodeToString(claimsJson), nil
+}
+
+// IsCompact reports whether AccessToken was signed or parsed in compact serialization
+func (t AccessToken) IsCompact() bool {
+	return t.Raw != ""
+}
+
+// CompactParts splits compact AccessToken into signing input and decoded signature
+func (t AccessToken) CompactParts() (signingInput string, signature []byte, err error) {
+	if !t.IsCompact() {
+		return "", nil, ErrMalformedCompactToken
+	}
+
+	idx := strings.LastIndexByte(t.Raw, '.')
+
+	if idx < 0 {
+		return "", nil, ErrMalformedCompactToken
+	}
+
+	signature, err = base64.RawURLEncoding.DecodeString(t.Raw[idx+1:])
+
+	if err != nil {
+		return "", nil, fmt.Errorf("incorrect Access token signature encoding: %w", err)
+	}
+
+	return t.Raw[:idx], signature, nil
+}
+
+// ParseCompactAccessToken parses RFC 7519 compact serialization of AccessToken
+// Signature is not verified
+func ParseCompactAccessToken(s string) (AccessToken, error) {
+	parts := strings.Split(s, ".")
+
+	if len(parts) != 3 {
+		return AccessToken{}, ErrMalformedCompactToken
+	}
+
+	headerJson, err := base64.RawURLEncoding.DecodeString(parts[0])
+
+	if err != nil {
+		return AccessToken{}, fmt.Errorf("incorrect Access token header encoding: %w", err)
+	}
+
+	var header compactHeader
+
+	if err = json.Unmarshal(headerJson, &header); err != nil {
+		return AccessToken{}, fmt.Errorf("failed to unmarshall Access token header: %w", err)
+	}
+
+	claimsJson, err := base64.RawURLEncoding.DecodeString(parts[1])
+
+	if err != nil {
+		return AccessToken{}, fmt.Errorf("incorrect Access token claims encoding: %w", err)
+	}
+
+	var claims compactClaims
+
+	if err = json.Unmarshal(claimsJson, &claims); err != nil {
+		return AccessToken{}, fmt.Errorf("failed to unmarshall Access token claims: %w", err)
+	}
+
+	if _, err = base64.RawURLEncoding.DecodeString(parts[2]); err != nil {
+		return AccessToken{}, fmt.Errorf("incorrect Access token signature encoding: %w", err)
+	}
+
+	return AccessToken{
+		Signature: parts[2],
+		Payload:   claims.AccessTokenPayload,
+		Header: AccessTokenHeader{
+			Type: header.Type,
+			Alg:  header.Alg,
+			Kid:  header.Kid,
+			Exp:  time.Unix(claims.Exp, 0),
+		},
+		Raw: s,
+	}, nil
+}
+
+// accessTokenObject is used to marshall AccessToken in legacy object format without recursion
+type accessTokenObject AccessToken
+
+// MarshalJSON encodes compact AccessToken as JSON string and legacy AccessToken as JSON object
+func (t AccessToken) MarshalJSON() ([]byte, error) {
+	if t.IsCompact() {
+		return json.Marshal(t.Raw)
+	}
+
+	return json.Marshal(accessTokenObject(t))
+}
+
+// UnmarshalJSON accepts both compact JSON string and legacy JSON object
+func (t *AccessToken) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+
+	var raw string
+
+	if err := json.Unmarshal(data, &raw); err == nil {
+		token, err := ParseCompactAccessToken(raw)
+
+		if err != nil {
+			return err
+		}
+
+		*t = token
+		return nil
+	}
+
+	var object accessTokenObject
+
+	if err := json.Unmarshal(data, &object); err != nil {
+		return err
+	}
+
+	*t = AccessToken(object)
+	return nil
+}
@@ -0,0 +1,121 @@
+package tokens
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccessToken_SigningInput(t *testing.T) {
+	token := NewAccessToken(time.Now(), "session")
+
+	input, err := token.SigningInput()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Count(input, ".") != 1 {
+		t.Fatalf("signing input must consist of two segments, got: %v", input)
+	}
+}
+
+func TestParseCompactAccessToken(t *testing.T) {
+	token := NewAccessToken(time.Now(), "session")
+
+	input, err := token.SigningInput()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw := input + ".c2lnbmF0dXJl"
+
+	parsed, err := ParseCompactAccessToken(raw)
+
+	if err != nil {
+		t.Fatalf("failed to parse compact token: %v", err)
+	}
+
+	if parsed.Payload != token.Payload {
+		t.Errorf("parsed token payload is not equal to initial token:\n%#v\n%#v", parsed.Payload, token.Payload)
+	}
+
+	if parsed.Header.Exp.Unix() != token.Header.Exp.Unix() {
+		t.Errorf("parsed token expiration is not equal to initial token: %v %v", parsed.Header.Exp, token.Header.Exp)
+	}
+
+	if parsed.Signature != "c2lnbmF0dXJl" {
+		t.Errorf("unexpected signature: %v", parsed.Signature)
+	}
+
+	parsedInput, signature, err := parsed.CompactParts()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parsedInput != input || string(signature) != "signature" {
+		t.Errorf("unexpected compact parts: %v %v", parsedInput, string(signature))
+	}
+
+	for _, malformed := range []string{"", "a.b", "a.b.c.d", "!!.e30.e30"} {
+		if _, err := ParseCompactAccessToken(malformed); err == nil {
+			t.Errorf("malformed token %q was parsed", malformed)
+		}
+	}
+}
+
+func TestAccessTokenJSON(t *testing.T) {
+	token := NewAccessToken(time.Now(), "session")
+
+	input, err := token.SigningInput()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token.Raw = input + ".c2lnbmF0dXJl"
+
+	pair := RefreshAccessTokenPair{AccessToken: token, RefreshToken: "refresh"}
+
+	data, err := json.Marshal(pair)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(data), `"access_token":"`+token.Raw+`"`) {
+		t.Fatalf("compact token must be marshalled as string, got: %s", data)
+	}
+
+	var loaded RefreshAccessTokenPair
+
+	if err = json.Unmarshal(data, &loaded); err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded.AccessToken.Raw != token.Raw || loaded.AccessToken.Payload != token.Payload {
+		t.Fatalf("loaded token is not equal to initial token:\n%#v\n%#v", loaded.AccessToken, token)
+	}
+
+	legacy := NewAccessToken(time.Now(), "session")
+	legacy.Signature = "signature"
+
+	data, err = json.Marshal(legacy)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var loadedLegacy AccessToken
+
+	if err = json.Unmarshal(data, &loadedLegacy); err != nil {
+		t.Fatal(err)
+	}
+
+	if loadedLegacy.IsCompact() || loadedLegacy.Signature != legacy.Signature || loadedLegacy.Payload != legacy.Payload {
+		t.Fatalf("loaded legacy token is not equal to initial token:\n%#v\n%#v", loadedLegacy, legacy)
+	}
+}
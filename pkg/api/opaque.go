@@ -0,0 +1,116 @@
+package tokens
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var ErrMalformedOpaqueToken error = errors.New("malformed opaque Refresh token")
+
+// OpaqueSecretSize is number of random bytes in OpaqueRefreshToken
+const OpaqueSecretSize int = 32
+
+// SessionRefreshToken is Refresh token which is looked up by session and verified against hash stored in session
+type SessionRefreshToken interface {
+	// Lookup returns session and generation the token was issued for
+	Lookup() (session string, generation int)
+	// Digest returns SHA-256 of token bound to GUID which is passed to TokenHasher
+	Digest(guid string) ([]byte, error)
+	// Encode returns string passed to client
+	Encode() (string, error)
+}
+
+// OpaqueRefreshToken is high-entropy random Refresh token, all its metadata is stored in session
+// String form is <session>.<generation>.<secret>, session and generation are used only to look up stored hash
+type OpaqueRefreshToken struct {
+	Session    string
+	Generation int
+	Secret     string
+}
+
+// NewOpaqueRefreshToken creates opaque Refresh token with random secret
+func NewOpaqueRefreshToken(session string, generation int) (OpaqueRefreshToken, error) {
+	secret := make([]byte, OpaqueSecretSize)
+
+	if _, err := rand.Read(secret); err != nil {
+		return OpaqueRefreshToken{}, fmt.Errorf("failed to generate opaque Refresh token secret: %w", err)
+	}
+
+	return OpaqueRefreshToken{
+		Session:    session,
+		Generation: generation,
+		Secret:     base64.RawURLEncoding.EncodeToString(secret),
+	}, nil
+}
+
+// ParseOpaqueRefreshToken parses string form of opaque Refresh token
+func ParseOpaqueRefreshToken(s string) (OpaqueRefreshToken, error) {
+	parts := strings.Split(s, ".")
+
+	if len(parts) != 3 || parts[0] == "" {
+		return OpaqueRefreshToken{}, ErrMalformedOpaqueToken
+	}
+
+	generation, err := strconv.Atoi(parts[1])
+
+	if err != nil || generation < 0 {
+		return OpaqueRefreshToken{}, ErrMalformedOpaqueToken
+	}
+
+	secret, err := base64.RawURLEncoding.DecodeString(parts[2])
+
+	if err != nil || len(secret) != OpaqueSecretSize {
+		return OpaqueRefreshToken{}, ErrMalformedOpaqueToken
+	}
+
+	return OpaqueRefreshToken{
+		Session:    parts[0],
+		Generation: generation,
+		Secret:     parts[2],
+	}, nil
+}
+
+// String returns form of token passed to client
+func (t OpaqueRefreshToken) String() string {
+	return t.Session + "." + strconv.Itoa(t.Generation) + "." + t.Secret
+}
+
+// Encode returns form of token passed to client
+func (t OpaqueRefreshToken) Encode() (string, error) {
+	return t.String(), nil
+}
+
+// Lookup returns session and generation of token
+func (t OpaqueRefreshToken) Lookup() (session string, generation int) {
+	return t.Session, t.Generation
+}
+
+// Digest binds token to GUID, SHA-256 keeps input within bcrypt limit of 72 bytes
+func (t OpaqueRefreshToken) Digest(guid string) ([]byte, error) {
+	sum := sha256.Sum256([]byte(t.String() + "\x00" + guid))
+	return sum[:], nil
+}
+
+// Hash computes a bcrypt hash of the token using the provided GUID.
+func (t OpaqueRefreshToken) Hash(guid string) (string, error) {
+	return HashToken(BcryptHasher{}, t, guid)
+}
+
+// Verify checks if the provided hash matches the hash of the token.
+func (t OpaqueRefreshToken) Verify(guid string, hash string) (bool, error) {
+	return VerifyToken(BcryptHasher{}, t, guid, hash)
+}
+
+// LoadRefreshToken loads opaque or legacy base64 Refresh token from string passed by client
+func LoadRefreshToken(s string) (SessionRefreshToken, error) {
+	if strings.Count(s, ".") == 2 {
+		return ParseOpaqueRefreshToken(s)
+	}
+
+	return LoadRefreshTokenFromBase64(s)
+}
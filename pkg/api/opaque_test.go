@@ -0,0 +1,94 @@
+package tokens
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOpaqueRefreshToken(t *testing.T) {
+	token, err := NewOpaqueRefreshToken("session", 3)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	another, err := NewOpaqueRefreshToken("session", 3)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if token.Secret == another.Secret {
+		t.Fatalf("opaque tokens secrets are equal")
+	}
+
+	encoded, err := token.Encode()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(encoded, "session.3.") {
+		t.Fatalf("opaque token has no lookup prefix: %v", encoded)
+	}
+
+	loaded, err := LoadRefreshToken(encoded)
+
+	if err != nil {
+		t.Fatalf("failed to load opaque token: %v", err)
+	}
+
+	if session, generation := loaded.Lookup(); session != "session" || generation != 3 {
+		t.Fatalf("unexpected lookup of loaded token: %v %v", session, generation)
+	}
+
+	hash, err := token.Hash("guid")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := VerifyToken(BcryptHasher{}, loaded, "guid", hash); err != nil || !ok {
+		t.Fatalf("failed to verify loaded token: %v", err)
+	}
+
+	if ok, _ := VerifyToken(BcryptHasher{}, loaded, "another guid", hash); ok {
+		t.Fatalf("token was verified with another GUID")
+	}
+
+	if ok, _ := another.Verify("guid", hash); ok {
+		t.Fatalf("another token was verified")
+	}
+
+	for _, malformed := range []string{"session.3", ".3.secret", "session.x." + token.Secret, "session.3.short", "session.-1." + token.Secret} {
+		if _, err := ParseOpaqueRefreshToken(malformed); err == nil {
+			t.Errorf("malformed token %q was parsed", malformed)
+		}
+	}
+}
+
+func TestLoadRefreshTokenLegacy(t *testing.T) {
+	token := NewRefreshToken("hello", time.Now(), "your ip")
+	token.Payload.Session = "session"
+
+	encoded, err := token.Encode()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadRefreshToken(encoded)
+
+	if err != nil {
+		t.Fatalf("failed to load legacy token: %v", err)
+	}
+
+	if _, ok := loaded.(RefreshToken); !ok {
+		t.Fatalf("legacy token was loaded as %T", loaded)
+	}
+
+	if session, _ := loaded.Lookup(); session != "session" {
+		t.Fatalf("unexpected session of loaded token: %v", session)
+	}
+}
@@ -4,11 +4,8 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"time"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
 // RefreshToken is used to Refresh Access token
@@ -18,10 +15,13 @@ type RefreshToken struct {
 }
 
 // RefreshTokenPayload is payload for Refresh token
-// Contains signature of AccessToken to be used with
+// Contains signature of AccessToken to be used with and session to look up Refresh token without Access token
+// Generation is number of rotations of session Refresh token when this token was issued
 type RefreshTokenPayload struct {
 	AccessTokenSignature string `json:"access_token_signature"`
 	Ip                   string `json:"source_ip"`
+	Session              string `json:"session,omitempty"`
+	Generation           int    `json:"gen,omitempty"`
 }
 
 // RefreshTokenHeader is header for Refresh token
@@ -85,41 +85,27 @@ func (t RefreshToken) calcSHA256Cut(guid string) ([]byte, error) {
 // Hash computes a bcrypt hash of the RefreshToken using the provided GUID.
 func (t RefreshToken) Hash(guid string) (string, error) {
 
-	shaHash, err := t.calcSHA256Cut(guid)
-
-	if err != nil {
-		return "", err
-	}
-
-	hash, err := bcrypt.GenerateFromPassword(shaHash, bcrypt.DefaultCost)
-
-	if err != nil {
-		return "", fmt.Errorf("failed to calculate bcrypt hash for refresh token: %w", err)
-	}
-
-	return base64.StdEncoding.EncodeToString(hash), nil
+	return HashToken(BcryptHasher{}, t, guid)
 }
 
 // Verify checks if the provided hash matches the hash of the current RefreshToken.
 func (t RefreshToken) Verify(guid string, hash string) (bool, error) {
-	shaHash, err := t.calcSHA256Cut(guid)
-
-	if err != nil {
-		return false, err
-	}
-
-	decodedHash, err := base64.StdEncoding.DecodeString(hash)
+	return VerifyToken(BcryptHasher{}, t, guid, hash)
+}
 
-	err = bcrypt.CompareHashAndPassword(decodedHash, shaHash)
+// Digest returns SHA-256 of token bound to GUID which is passed to TokenHasher
+func (t RefreshToken) Digest(guid string) ([]byte, error) {
+	return t.calcSHA256Cut(guid)
+}
 
-	if err != nil {
-		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
-			return false, nil
-		}
-		return false, err
-	}
+// Lookup returns session and generation of RefreshToken
+func (t RefreshToken) Lookup() (session string, generation int) {
+	return t.Payload.Session, t.Payload.Generation
+}
 
-	return true, nil
+// Encode encodes RefreshToken in base64
+func (t RefreshToken) Encode() (string, error) {
+	return t.Base64()
 }
 
 // LoadRefreshTokenFromBase64 loads Refresh token from base64 encoding
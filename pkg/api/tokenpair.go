@@ -1,7 +1,15 @@
 package tokens
 
+// Token types of RefreshAccessTokenPair
+const (
+	TokenTypeBearer string = "Bearer"
+	TokenTypeDPoP   string = "DPoP"
+)
+
 // RefreshAccessTokenPair is pair of Refresh and Access tokens that is used in Refresh and Auth request
 type RefreshAccessTokenPair struct {
 	AccessToken  AccessToken `json:"access_token"`
 	RefreshToken string      `json:"refresh_token"`
+	// TokenType is Bearer or DPoP for tokens bound to DPoP proof key
+	TokenType string `json:"token_type,omitempty"`
 }
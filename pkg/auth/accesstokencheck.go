@@ -2,40 +2,53 @@ package auth
 
 import (
 	api "authservice/pkg/api"
-	"crypto/sha512"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 )
 
-// CalculateAccessTokenHash calculates the hash of the given access token using the provided secret.
-func CalculateAccessTokenHash(token api.AccessToken, secret string) (hash string, err error) {
-	shaObject := sha512.New()
+var ErrInvalidSignature error = errors.New("invalid Access token signature")
 
+// legacySigningInput returns data signed in legacy object format: payload json followed by header json
+func legacySigningInput(token api.AccessToken) ([]byte, error) {
 	payloadData, err := json.Marshal(token.Payload)
 
 	if err != nil {
-		return "", fmt.Errorf("incorrect payload for access token: %w", err)
+		return nil, fmt.Errorf("incorrect payload for access token: %w", err)
 	}
 
-	shaObject.Write(payloadData)
-
 	headerData, err := json.Marshal(token.Header)
 
 	if err != nil {
-		return "", fmt.Errorf("incorrect header for access token: %w", err)
+		return nil, fmt.Errorf("incorrect header for access token: %w", err)
 	}
 
-	shaObject.Write(headerData)
+	return append(payloadData, headerData...), nil
+}
 
-	shaObject.Write([]byte(secret))
+// CalculateAccessTokenHash calculates HMAC of the given access token in legacy format using the provided secret.
+// HMAC algorithm is taken from token header.
+func CalculateAccessTokenHash(token api.AccessToken, secret string) (hash string, err error) {
+	signer, err := NewHMACSigner(token.Header.Alg, []byte(secret))
+
+	if err != nil {
+		return "", err
+	}
 
-	finalHash := shaObject.Sum(nil)
+	data, err := legacySigningInput(token)
 
-	hash = base64.StdEncoding.EncodeToString(finalHash)
+	if err != nil {
+		return "", err
+	}
+
+	signature, err := signer.Sign(data)
+
+	if err != nil {
+		return "", err
+	}
 
-	return
+	return base64.StdEncoding.EncodeToString(signature), nil
 }
 
 var ErrNilPointerToken error = errors.New("passed nil as *tokens.AccessToken")
@@ -57,3 +70,148 @@ func SignAccessToken(token *api.AccessToken, secret string) (err error) {
 
 	return nil
 }
+
+// SignAccessTokenWith signs the given access token in legacy object format, header algorithm is set to signer algorithm.
+func SignAccessTokenWith(token *api.AccessToken, signer Signer) (err error) {
+
+	if token == nil {
+		return ErrNilPointerToken
+	}
+
+	token.Header.Alg = signer.Alg()
+
+	data, err := legacySigningInput(*token)
+
+	if err != nil {
+		return fmt.Errorf("incorrect token for signature calculation: %v", err)
+	}
+
+	signature, err := signer.Sign(data)
+
+	if err != nil {
+		return fmt.Errorf("failed to sign access token: %w", err)
+	}
+
+	token.Signature = base64.StdEncoding.EncodeToString(signature)
+	token.Raw = ""
+
+	return nil
+}
+
+// SignAccessTokenCompact signs the given access token with HMAC algorithm from token header
+// and stores its RFC 7519 compact serialization in token.Raw.
+func SignAccessTokenCompact(token *api.AccessToken, secret string) (err error) {
+
+	if token == nil {
+		return ErrNilPointerToken
+	}
+
+	signer, err := NewHMACSigner(token.Header.Alg, []byte(secret))
+
+	if err != nil {
+		return err
+	}
+
+	return SignAccessTokenCompactWith(token, signer)
+}
+
+// SignAccessTokenCompactWith signs the given access token with signer
+// and stores its RFC 7519 compact serialization in token.Raw.
+func SignAccessTokenCompactWith(token *api.AccessToken, signer Signer) (err error) {
+
+	if token == nil {
+		return ErrNilPointerToken
+	}
+
+	token.Header.Alg = signer.Alg()
+
+	signingInput, err := token.SigningInput()
+
+	if err != nil {
+		return fmt.Errorf("incorrect token for signature calculation: %v", err)
+	}
+
+	signature, err := signer.Sign([]byte(signingInput))
+
+	if err != nil {
+		return fmt.Errorf("failed to sign access token: %w", err)
+	}
+
+	token.Signature = base64.RawURLEncoding.EncodeToString(signature)
+	token.Raw = signingInput + "." + token.Signature
+
+	return nil
+}
+
+// VerifyAccessToken checks HMAC signature of the given access token in either compact or legacy format.
+func VerifyAccessToken(token api.AccessToken, secret string) error {
+	verifier, err := NewHMACSigner(token.Header.Alg, []byte(secret))
+
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+
+	return VerifyAccessTokenWith(token, verifier)
+}
+
+// VerifyAccessTokenWith checks signature of the given access token in either compact or legacy format.
+// Token is rejected if its header algorithm differs from verifier algorithm.
+func VerifyAccessTokenWith(token api.AccessToken, verifier Verifier) error {
+	if token.Header.Alg != verifier.Alg() {
+		return fmt.Errorf("%w: unexpected algorithm %v", ErrInvalidSignature, token.Header.Alg)
+	}
+
+	if token.IsCompact() {
+		signingInput, signature, err := token.CompactParts()
+
+		if err != nil {
+			return err
+		}
+
+		return verifier.Verify([]byte(signingInput), signature)
+	}
+
+	data, err := legacySigningInput(token)
+
+	if err != nil {
+		return err
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(token.Signature)
+
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+
+	return verifier.Verify(data, signature)
+}
+
+// ParseAccessToken parses compact serialization of access token and verifies its HMAC signature.
+func ParseAccessToken(s string, secret string) (api.AccessToken, error) {
+	token, err := api.ParseCompactAccessToken(s)
+
+	if err != nil {
+		return api.AccessToken{}, err
+	}
+
+	if err = VerifyAccessToken(token, secret); err != nil {
+		return api.AccessToken{}, err
+	}
+
+	return token, nil
+}
+
+// ParseAccessTokenWith parses compact serialization of access token and verifies its signature.
+func ParseAccessTokenWith(s string, verifier Verifier) (api.AccessToken, error) {
+	token, err := api.ParseCompactAccessToken(s)
+
+	if err != nil {
+		return api.AccessToken{}, err
+	}
+
+	if err = VerifyAccessTokenWith(token, verifier); err != nil {
+		return api.AccessToken{}, err
+	}
+
+	return token, nil
+}
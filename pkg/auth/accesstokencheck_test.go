@@ -2,13 +2,14 @@ package auth
 
 import (
 	api "authservice/pkg/api"
+	"errors"
 	"fmt"
 	"testing"
 	"time"
 )
 
 func TestCalculateAccessTokenHash(t *testing.T) {
-	token := api.NewAccessToken(time.Now(), "session")
+	token := api.NewAccessToken(time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC), "session")
 
 	secret := "my interesting secret"
 
@@ -38,7 +39,7 @@ func TestCalculateAccessTokenHash(t *testing.T) {
 }
 
 func TestSignAccessToken(t *testing.T) {
-	token := api.NewAccessToken(time.Now(), "session")
+	token := api.NewAccessToken(time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC), "session")
 	secret := "my inrerecting token"
 
 	signature, err := CalculateAccessTokenHash(token, secret)
@@ -58,3 +59,65 @@ func TestSignAccessToken(t *testing.T) {
 			signature, token.Signature)
 	}
 }
+
+func TestSignAccessTokenCompact(t *testing.T) {
+	token := api.NewAccessToken(time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC), "session")
+	secret := "my interesting secret"
+
+	if err := SignAccessTokenCompact(&token, secret); err != nil {
+		t.Fatal(err)
+	}
+
+	if !token.IsCompact() {
+		t.Fatalf("compact serialization was not stored in token")
+	}
+
+	if err := VerifyAccessToken(token, secret); err != nil {
+		t.Fatalf("failed to verify signed token: %v", err)
+	}
+
+	parsed, err := ParseAccessToken(token.Raw, secret)
+
+	if err != nil {
+		t.Fatalf("failed to parse signed token: %v", err)
+	}
+
+	if parsed.Payload != token.Payload {
+		t.Fatalf("parsed token payload is not equal to signed token: %#v %#v", parsed.Payload, token.Payload)
+	}
+
+	if _, err := ParseAccessToken(token.Raw, "another secret"); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("token with another secret must not be verified, got: %v", err)
+	}
+
+	tampered := api.NewAccessToken(time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC).Add(time.Hour), "session")
+
+	input, err := tampered.SigningInput()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ParseAccessToken(input+"."+token.Signature, secret); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("tampered token must not be verified, got: %v", err)
+	}
+}
+
+func TestVerifyAccessTokenLegacy(t *testing.T) {
+	token := api.NewAccessToken(time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC), "session")
+	secret := "my interesting secret"
+
+	if err := SignAccessToken(&token, secret); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := VerifyAccessToken(token, secret); err != nil {
+		t.Fatalf("failed to verify signed token: %v", err)
+	}
+
+	token.Payload.Session = "another session"
+
+	if err := VerifyAccessToken(token, secret); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("tampered token must not be verified, got: %v", err)
+	}
+}
@@ -0,0 +1,279 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+)
+
+// Supported asymmetric algorithms as defined by RFC 7518 and RFC 8037
+const (
+	AlgRS256 string = "RS256"
+	AlgES256 string = "ES256"
+	AlgEdDSA string = "EdDSA"
+)
+
+var ErrUnsupportedKey error = errors.New("unsupported key type")
+
+// PublicKeyVerifier is Verifier backed by public key which can be published
+type PublicKeyVerifier interface {
+	Verifier
+	PublicKey() crypto.PublicKey
+}
+
+// es256CoordinateSize is size of r and s values in ES256 signature
+const es256CoordinateSize int = 32
+
+// RSAVerifier verifies RS256 signatures with RSA public key
+type RSAVerifier struct {
+	key *rsa.PublicKey
+}
+
+func (v *RSAVerifier) Alg() string {
+	return AlgRS256
+}
+
+func (v *RSAVerifier) PublicKey() crypto.PublicKey {
+	return v.key
+}
+
+func (v *RSAVerifier) Verify(data []byte, signature []byte) error {
+	digest := sha256.Sum256(data)
+
+	if err := rsa.VerifyPKCS1v15(v.key, crypto.SHA256, digest[:], signature); err != nil {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// RSASigner signs Access tokens with RS256
+type RSASigner struct {
+	RSAVerifier
+	private *rsa.PrivateKey
+}
+
+func (s *RSASigner) Sign(data []byte) ([]byte, error) {
+	digest := sha256.Sum256(data)
+
+	return rsa.SignPKCS1v15(rand.Reader, s.private, crypto.SHA256, digest[:])
+}
+
+// ECDSAVerifier verifies ES256 signatures with ECDSA P-256 public key
+type ECDSAVerifier struct {
+	key *ecdsa.PublicKey
+}
+
+func (v *ECDSAVerifier) Alg() string {
+	return AlgES256
+}
+
+func (v *ECDSAVerifier) PublicKey() crypto.PublicKey {
+	return v.key
+}
+
+// Verify expects signature as concatenated r and s values as defined by RFC 7518
+func (v *ECDSAVerifier) Verify(data []byte, signature []byte) error {
+	if len(signature) != 2*es256CoordinateSize {
+		return ErrInvalidSignature
+	}
+
+	digest := sha256.Sum256(data)
+
+	r := new(big.Int).SetBytes(signature[:es256CoordinateSize])
+	s := new(big.Int).SetBytes(signature[es256CoordinateSize:])
+
+	if !ecdsa.Verify(v.key, digest[:], r, s) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// ECDSASigner signs Access tokens with ES256
+type ECDSASigner struct {
+	ECDSAVerifier
+	private *ecdsa.PrivateKey
+}
+
+func (s *ECDSASigner) Sign(data []byte) ([]byte, error) {
+	digest := sha256.Sum256(data)
+
+	r, sv, err := ecdsa.Sign(rand.Reader, s.private, digest[:])
+
+	if err != nil {
+		return nil, err
+	}
+
+	signature := make([]byte, 2*es256CoordinateSize)
+
+	r.FillBytes(signature[:es256CoordinateSize])
+	sv.FillBytes(signature[es256CoordinateSize:])
+
+	return signature, nil
+}
+
+// Ed25519Verifier verifies EdDSA signatures with Ed25519 public key
+type Ed25519Verifier struct {
+	key ed25519.PublicKey
+}
+
+func (v *Ed25519Verifier) Alg() string {
+	return AlgEdDSA
+}
+
+func (v *Ed25519Verifier) PublicKey() crypto.PublicKey {
+	return v.key
+}
+
+func (v *Ed25519Verifier) Verify(data []byte, signature []byte) error {
+	if !ed25519.Verify(v.key, data, signature) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// Ed25519Signer signs Access tokens with EdDSA
+type Ed25519Signer struct {
+	Ed25519Verifier
+	private ed25519.PrivateKey
+}
+
+func (s *Ed25519Signer) Sign(data []byte) ([]byte, error) {
+	return ed25519.Sign(s.private, data), nil
+}
+
+// NewVerifier creates Verifier for RSA (at least 2048 bits), ECDSA P-256 or Ed25519 public key
+func NewVerifier(key crypto.PublicKey) (Verifier, error) {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if k.N.BitLen() < minRSAKeySize {
+			return nil, fmt.Errorf("%w: RSA key of %v bits", ErrUnsupportedKey, k.N.BitLen())
+		}
+		return &RSAVerifier{key: k}, nil
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("%w: ECDSA curve %v", ErrUnsupportedKey, k.Curve.Params().Name)
+		}
+		return &ECDSAVerifier{key: k}, nil
+	case ed25519.PublicKey:
+		return &Ed25519Verifier{key: k}, nil
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedKey, key)
+	}
+}
+
+// NewSigner creates Signer for RSA (at least 2048 bits), ECDSA P-256 or Ed25519 private key
+func NewSigner(key crypto.PrivateKey) (Signer, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < minRSAKeySize {
+			return nil, fmt.Errorf("%w: RSA key of %v bits", ErrUnsupportedKey, k.N.BitLen())
+		}
+		return &RSASigner{RSAVerifier: RSAVerifier{key: &k.PublicKey}, private: k}, nil
+	case *ecdsa.PrivateKey:
+		if k.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("%w: ECDSA curve %v", ErrUnsupportedKey, k.Curve.Params().Name)
+		}
+		return &ECDSASigner{ECDSAVerifier: ECDSAVerifier{key: &k.PublicKey}, private: k}, nil
+	case ed25519.PrivateKey:
+		return &Ed25519Signer{Ed25519Verifier: Ed25519Verifier{key: k.Public().(ed25519.PublicKey)}, private: k}, nil
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedKey, key)
+	}
+}
+
+// LoadSignerFromPEM loads Signer from PKCS #8, PKCS #1 or SEC 1 encoded private key
+func LoadSignerFromPEM(data []byte) (Signer, error) {
+	block, _ := pem.Decode(data)
+
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+
+	var key crypto.PrivateKey
+	var err error
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%w: PEM block %v", ErrUnsupportedKey, block.Type)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	return NewSigner(key)
+}
+
+// LoadVerifierFromPEM loads Verifier from PKIX public key, PKCS #1 RSA public key or certificate
+func LoadVerifierFromPEM(data []byte) (Verifier, error) {
+	block, _ := pem.Decode(data)
+
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+
+	var key crypto.PublicKey
+	var err error
+
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		cert, err = x509.ParseCertificate(block.Bytes)
+		if err == nil {
+			key = cert.PublicKey
+		}
+	default:
+		return nil, fmt.Errorf("%w: PEM block %v", ErrUnsupportedKey, block.Type)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+
+	return NewVerifier(key)
+}
+
+// LoadSignerFromPEMFile loads Signer from PEM file with private key
+func LoadSignerFromPEMFile(path string) (Signer, error) {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key file: %w", err)
+	}
+
+	return LoadSignerFromPEM(data)
+}
+
+// LoadVerifierFromPEMFile loads Verifier from PEM file with public key or certificate
+func LoadVerifierFromPEMFile(path string) (Verifier, error) {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key file: %w", err)
+	}
+
+	return LoadVerifierFromPEM(data)
+}
@@ -0,0 +1,175 @@
+package auth
+
+import (
+	api "authservice/pkg/api"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// generateTestKeys returns PEM encoded private and public keys for every supported algorithm
+func generateTestKeys(t *testing.T) map[string][2][]byte {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := map[string]crypto.Signer{
+		AlgRS256: rsaKey,
+		AlgES256: ecKey,
+		AlgEdDSA: edKey,
+	}
+
+	result := map[string][2][]byte{}
+
+	for alg, key := range keys {
+		private, err := x509.MarshalPKCS8PrivateKey(key)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		public, err := x509.MarshalPKIXPublicKey(key.Public())
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result[alg] = [2][]byte{
+			pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: private}),
+			pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public}),
+		}
+	}
+
+	return result
+}
+
+func TestAsymmetricSigners(t *testing.T) {
+	dir := t.TempDir()
+
+	for alg, pair := range generateTestKeys(t) {
+		privatePath := filepath.Join(dir, alg+".key")
+		publicPath := filepath.Join(dir, alg+".pub")
+
+		if err := os.WriteFile(privatePath, pair[0], 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(publicPath, pair[1], 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		signer, err := LoadSignerFromPEMFile(privatePath)
+
+		if err != nil {
+			t.Fatalf("failed to load %v signer: %v", alg, err)
+		}
+
+		verifier, err := LoadVerifierFromPEMFile(publicPath)
+
+		if err != nil {
+			t.Fatalf("failed to load %v verifier: %v", alg, err)
+		}
+
+		if signer.Alg() != alg || verifier.Alg() != alg {
+			t.Fatalf("unexpected algorithms for %v key: %v %v", alg, signer.Alg(), verifier.Alg())
+		}
+
+		token := api.NewAccessToken(time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC), "session")
+
+		if err := SignAccessTokenCompactWith(&token, signer); err != nil {
+			t.Fatalf("failed to sign token with %v: %v", alg, err)
+		}
+
+		if _, err := ParseAccessTokenWith(token.Raw, verifier); err != nil {
+			t.Fatalf("failed to verify %v token with public key: %v", alg, err)
+		}
+
+		token.Payload.Session = "another session"
+
+		if err := SignAccessTokenWith(&token, signer); err != nil {
+			t.Fatalf("failed to sign legacy token with %v: %v", alg, err)
+		}
+
+		if err := VerifyAccessTokenWith(token, verifier); err != nil {
+			t.Fatalf("failed to verify %v legacy token with public key: %v", alg, err)
+		}
+
+		token.Payload.Session = "session"
+
+		if err := VerifyAccessTokenWith(token, verifier); !errors.Is(err, ErrInvalidSignature) {
+			t.Fatalf("tampered %v token was verified", alg)
+		}
+	}
+}
+
+func TestNewSignerUnsupportedCurve(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewSigner(key); !errors.Is(err, ErrUnsupportedKey) {
+		t.Fatalf("P-384 key was accepted for ES256")
+	}
+}
+
+func TestLoadWeakRSAKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	public, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	privatePath := filepath.Join(dir, "private.pem")
+	publicPath := filepath.Join(dir, "public.pem")
+
+	if err := os.WriteFile(privatePath, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(publicPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadSignerFromPEMFile(privatePath); !errors.Is(err, ErrUnsupportedKey) {
+		t.Fatalf("1024 bit RSA private key was accepted: %v", err)
+	}
+
+	if _, err := LoadVerifierFromPEMFile(publicPath); !errors.Is(err, ErrUnsupportedKey) {
+		t.Fatalf("1024 bit RSA public key was accepted: %v", err)
+	}
+}
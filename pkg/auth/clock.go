@@ -0,0 +1,72 @@
+package auth
+
+import (
+	api "authservice/pkg/api"
+	"fmt"
+	"time"
+)
+
+// Clock returns current time, it is replaced in tests
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is Clock returning time.Now
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// ClockFunc adapts function to Clock
+type ClockFunc func() time.Time
+
+func (f ClockFunc) Now() time.Time {
+	return f()
+}
+
+// TimeValidator checks "exp", "nbf" and "iat" of Access token
+// Leeway tolerates clock skew between this service and token issuer, zero Clock is SystemClock
+type TimeValidator struct {
+	Clock  Clock
+	Leeway time.Duration
+}
+
+// Now returns current time of validator clock
+func (v TimeValidator) Now() time.Time {
+	if v.Clock == nil {
+		return time.Now()
+	}
+
+	return v.Clock.Now()
+}
+
+// Validate checks that token is already valid and not expired
+func (v TimeValidator) Validate(token api.AccessToken) error {
+	if err := v.ValidateIssued(token); err != nil {
+		return err
+	}
+
+	if v.Now().After(token.Header.Exp.Add(v.Leeway)) {
+		return ErrTokenExpired
+	}
+
+	return nil
+}
+
+// ValidateIssued checks that token is already valid, expiry is not checked
+// It is used on refresh where Access token is expected to be expired
+func (v TimeValidator) ValidateIssued(token api.AccessToken) error {
+	now := v.Now().Add(v.Leeway)
+	payload := token.Payload
+
+	if payload.NotBefore != 0 && now.Before(time.Unix(payload.NotBefore, 0)) {
+		return ErrTokenNotYetValid
+	}
+
+	if payload.IssuedAt != 0 && now.Before(time.Unix(payload.IssuedAt, 0)) {
+		return fmt.Errorf("%w: issued in future", ErrInvalidClaims)
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package auth
+
+import (
+	api "authservice/pkg/api"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTimeValidator(t *testing.T) {
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	clock := ClockFunc(func() time.Time { return now })
+
+	strict := TimeValidator{Clock: clock}
+	lenient := TimeValidator{Clock: clock, Leeway: time.Minute}
+
+	tests := []struct {
+		Name   string
+		Change func(token *api.AccessToken)
+		// Err is expected without leeway, LeewayErr with leeway of one minute
+		Err       error
+		LeewayErr error
+		// IssuedErr is expected from ValidateIssued without leeway
+		IssuedErr error
+	}{
+		{Name: "Ok", Change: func(token *api.AccessToken) {}},
+		{Name: "Expired within leeway", Change: func(token *api.AccessToken) { token.Header.Exp = now.Add(-30 * time.Second) }, Err: ErrTokenExpired},
+		{Name: "Expired", Change: func(token *api.AccessToken) { token.Header.Exp = now.Add(-2 * time.Minute) }, Err: ErrTokenExpired, LeewayErr: ErrTokenExpired},
+		{Name: "Not before within leeway", Change: func(token *api.AccessToken) { token.Payload.NotBefore = now.Add(30 * time.Second).Unix() }, Err: ErrTokenNotYetValid, IssuedErr: ErrTokenNotYetValid},
+		{Name: "Not before", Change: func(token *api.AccessToken) { token.Payload.NotBefore = now.Add(2 * time.Minute).Unix() }, Err: ErrTokenNotYetValid, LeewayErr: ErrTokenNotYetValid, IssuedErr: ErrTokenNotYetValid},
+		{Name: "Issued in future within leeway", Change: func(token *api.AccessToken) { token.Payload.IssuedAt = now.Add(30 * time.Second).Unix() }, Err: ErrInvalidClaims, IssuedErr: ErrInvalidClaims},
+		{Name: "Issued in future", Change: func(token *api.AccessToken) { token.Payload.IssuedAt = now.Add(2 * time.Minute).Unix() }, Err: ErrInvalidClaims, LeewayErr: ErrInvalidClaims, IssuedErr: ErrInvalidClaims},
+	}
+
+	check := func(t *testing.T, err error, expected error) {
+		t.Helper()
+
+		if expected == nil && err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if expected != nil && !errors.Is(err, expected) {
+			t.Fatalf("expected error %v, got %v", expected, err)
+		}
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			token := newTestToken(now)
+			test.Change(&token)
+
+			check(t, strict.Validate(token), test.Err)
+			check(t, lenient.Validate(token), test.LeewayErr)
+			check(t, strict.ValidateIssued(token), test.IssuedErr)
+		})
+	}
+}
+
+func TestTimeValidatorSystemClock(t *testing.T) {
+	var validator TimeValidator
+
+	if err := validator.Validate(newTestToken(time.Now())); err != nil {
+		t.Fatalf("zero TimeValidator must use system clock: %v", err)
+	}
+}
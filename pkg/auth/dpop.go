@@ -0,0 +1,454 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DPoP headers and values as defined by RFC 9449
+const (
+	DPoPHeader      string = "DPoP"
+	DPoPNonceHeader string = "DPoP-Nonce"
+	// DPoPScheme is Authorization scheme of DPoP bound Access tokens
+	DPoPScheme string = "DPoP"
+	// DPoPProofType is "typ" of DPoP proof header
+	DPoPProofType string = "dpop+jwt"
+)
+
+// DefaultDPoPProofMaxAge is accepted age of DPoP proof when DPoPValidator.MaxAge is zero
+const DefaultDPoPProofMaxAge time.Duration = time.Minute * 5
+
+var ErrInvalidDPoPProof error = errors.New("invalid DPoP proof")
+var ErrUseDPoPNonce error = errors.New("DPoP proof must contain server nonce")
+
+// DPoPProofClaims are claims of DPoP proof
+type DPoPProofClaims struct {
+	ID       string `json:"jti"`
+	Method   string `json:"htm"`
+	URI      string `json:"htu"`
+	IssuedAt int64  `json:"iat"`
+	// AccessTokenHash binds proof to Access token presented with it
+	AccessTokenHash string `json:"ath,omitempty"`
+	Nonce           string `json:"nonce,omitempty"`
+}
+
+type dpopProofHeader struct {
+	Type string          `json:"typ"`
+	Alg  string          `json:"alg"`
+	JWK  json.RawMessage `json:"jwk"`
+}
+
+// DPoPProof is DPoP proof with verified signature
+type DPoPProof struct {
+	Claims DPoPProofClaims
+	// Thumbprint is RFC 7638 thumbprint of proof key, bound Access tokens carry it as cnf.jkt
+	Thumbprint string
+}
+
+// AccessTokenHash calculates "ath" claim of DPoP proof for Access token
+func AccessTokenHash(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func invalidDPoPProof(format string, args ...any) error {
+	return fmt.Errorf("%w: %v", ErrInvalidDPoPProof, fmt.Sprintf(format, args...))
+}
+
+// ParseDPoPProof parses DPoP proof and verifies its signature with public key embedded in proof header
+// Other checks are done by DPoPValidator
+func ParseDPoPProof(s string) (DPoPProof, error) {
+	parts := strings.Split(s, ".")
+
+	if len(parts) != 3 {
+		return DPoPProof{}, invalidDPoPProof("malformed proof")
+	}
+
+	headerJson, err := base64.RawURLEncoding.DecodeString(parts[0])
+
+	if err != nil {
+		return DPoPProof{}, invalidDPoPProof("malformed header")
+	}
+
+	var header dpopProofHeader
+
+	if err = json.Unmarshal(headerJson, &header); err != nil {
+		return DPoPProof{}, invalidDPoPProof("malformed header")
+	}
+
+	if header.Type != DPoPProofType {
+		return DPoPProof{}, invalidDPoPProof("unexpected typ %v", header.Type)
+	}
+
+	if len(header.JWK) == 0 {
+		return DPoPProof{}, invalidDPoPProof("no jwk")
+	}
+
+	var jwk JWK
+
+	// Private key parameter means client leaked its key
+	var private struct {
+		D string `json:"d"`
+	}
+
+	if json.Unmarshal(header.JWK, &jwk) != nil || json.Unmarshal(header.JWK, &private) != nil {
+		return DPoPProof{}, invalidDPoPProof("malformed jwk")
+	}
+
+	if private.D != "" {
+		return DPoPProof{}, invalidDPoPProof("jwk contains private key")
+	}
+
+	publicKey, err := jwk.PublicKey()
+
+	if err != nil {
+		return DPoPProof{}, invalidDPoPProof("%v", err)
+	}
+
+	verifier, err := NewVerifier(publicKey)
+
+	if err != nil {
+		return DPoPProof{}, invalidDPoPProof("%v", err)
+	}
+
+	if verifier.Alg() != header.Alg {
+		return DPoPProof{}, invalidDPoPProof("alg %v does not match jwk", header.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+
+	if err != nil {
+		return DPoPProof{}, invalidDPoPProof("malformed signature")
+	}
+
+	if err = verifier.Verify([]byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return DPoPProof{}, invalidDPoPProof("%v", err)
+	}
+
+	claimsJson, err := base64.RawURLEncoding.DecodeString(parts[1])
+
+	if err != nil {
+		return DPoPProof{}, invalidDPoPProof("malformed claims")
+	}
+
+	var claims DPoPProofClaims
+
+	if err = json.Unmarshal(claimsJson, &claims); err != nil {
+		return DPoPProof{}, invalidDPoPProof("malformed claims")
+	}
+
+	if claims.ID == "" || claims.Method == "" || claims.URI == "" || claims.IssuedAt == 0 {
+		return DPoPProof{}, invalidDPoPProof("jti, htm, htu and iat are required")
+	}
+
+	thumbprint, err := jwk.Thumbprint()
+
+	if err != nil {
+		return DPoPProof{}, invalidDPoPProof("%v", err)
+	}
+
+	return DPoPProof{
+		Claims:     claims,
+		Thumbprint: thumbprint,
+	}, nil
+}
+
+// NewDPoPProof creates DPoP proof signed with asymmetric signer, it is used by Go clients
+// accessToken and nonce are omitted from proof when empty
+func NewDPoPProof(signer Signer, method string, uri string, accessToken string, nonce string, now time.Time) (string, error) {
+	jwk, err := NewJWK("", signer)
+
+	if err != nil {
+		return "", err
+	}
+
+	jwk.Use = ""
+
+	id := make([]byte, 16)
+
+	if _, err = rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to generate DPoP proof jti: %w", err)
+	}
+
+	claims := DPoPProofClaims{
+		ID:       base64.RawURLEncoding.EncodeToString(id),
+		Method:   method,
+		URI:      uri,
+		IssuedAt: now.Unix(),
+		Nonce:    nonce,
+	}
+
+	if accessToken != "" {
+		claims.AccessTokenHash = AccessTokenHash(accessToken)
+	}
+
+	header := struct {
+		Type string `json:"typ"`
+		Alg  string `json:"alg"`
+		JWK  JWK    `json:"jwk"`
+	}{DPoPProofType, signer.Alg(), jwk}
+
+	headerJson, err := json.Marshal(header)
+
+	if err != nil {
+		return "", fmt.Errorf("failed to marshall DPoP proof header: %w", err)
+	}
+
+	claimsJson, err := json.Marshal(claims)
+
+	if err != nil {
+		return "", fmt.Errorf("failed to marshall DPoP proof claims: %w", err)
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJson) + "." + base64.RawURLEncoding.EncodeToString(claimsJson)
+
+	signature, err := signer.Sign([]byte(signingInput))
+
+	if err != nil {
+		return "", err
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// DPoPNonces issues and checks stateless server nonces
+// Nonce is HMAC of time window number, it is accepted during its window and the next one,
+// so instances sharing secret accept nonces of each other
+type DPoPNonces struct {
+	secret []byte
+	window time.Duration
+}
+
+// NewDPoPNonces creates DPoPNonces with nonces rotated every window, zero window means DefaultDPoPProofMaxAge
+func NewDPoPNonces(secret []byte, window time.Duration) *DPoPNonces {
+	if window <= 0 {
+		window = DefaultDPoPProofMaxAge
+	}
+
+	return &DPoPNonces{
+		secret: secret,
+		window: window,
+	}
+}
+
+func (n *DPoPNonces) nonce(counter uint64) string {
+	data := binary.BigEndian.AppendUint64(nil, counter)
+
+	mac := hmac.New(sha256.New, n.secret)
+	mac.Write(data)
+
+	return base64.RawURLEncoding.EncodeToString(append(data, mac.Sum(nil)[:16]...))
+}
+
+func (n *DPoPNonces) counter(now time.Time) uint64 {
+	return uint64(now.UnixNano() / int64(n.window))
+}
+
+// Nonce returns nonce of current window
+func (n *DPoPNonces) Nonce(now time.Time) string {
+	return n.nonce(n.counter(now))
+}
+
+// Valid checks that nonce was issued in current or previous window
+func (n *DPoPNonces) Valid(nonce string, now time.Time) bool {
+	data, err := base64.RawURLEncoding.DecodeString(nonce)
+
+	if err != nil || len(data) != 24 {
+		return false
+	}
+
+	counter := binary.BigEndian.Uint64(data[:8])
+	current := n.counter(now)
+
+	if counter != current && counter+1 != current {
+		return false
+	}
+
+	return hmac.Equal([]byte(n.nonce(counter)), []byte(nonce))
+}
+
+// DPoPReplayCache remembers used proof jtis until proofs expire
+// It is safe for concurrent use
+type DPoPReplayCache struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+	// queue holds keys in order they were used, expired keys are dropped from its head
+	// so each key is visited once instead of scanning whole cache on every proof
+	queue []replayEntry
+}
+
+type replayEntry struct {
+	key       string
+	expiresAt time.Time
+}
+
+func NewDPoPReplayCache() *DPoPReplayCache {
+	return &DPoPReplayCache{
+		seen: map[string]time.Time{},
+	}
+}
+
+// Use records key of proof, it returns false when key was already used and has not expired
+func (c *DPoPReplayCache) Use(key string, expiresAt time.Time, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expire(now)
+
+	if seenExpiresAt, ok := c.seen[key]; ok && !now.After(seenExpiresAt) {
+		return false
+	}
+
+	c.seen[key] = expiresAt
+	c.queue = append(c.queue, replayEntry{key: key, expiresAt: expiresAt})
+
+	return true
+}
+
+// expire drops expired keys from head of queue, must be called with lock held
+// Proofs expire in about order they are used, key behind longer lived one is dropped a bit later but never early
+func (c *DPoPReplayCache) expire(now time.Time) {
+	for len(c.queue) > 0 && now.After(c.queue[0].expiresAt) {
+		entry := c.queue[0]
+		c.queue = c.queue[1:]
+
+		// Key used again after it expired belongs to later entry
+		if seenExpiresAt, ok := c.seen[entry.key]; ok && seenExpiresAt.Equal(entry.expiresAt) {
+			delete(c.seen, entry.key)
+		}
+	}
+}
+
+// DPoPValidator checks DPoP proofs of requests
+type DPoPValidator struct {
+	// Time checks proof freshness with clock skew leeway
+	Time TimeValidator
+	// MaxAge is accepted proof age, zero means DefaultDPoPProofMaxAge
+	MaxAge time.Duration
+	// Nonces makes server nonce required when set
+	Nonces *DPoPNonces
+	// Replay rejects proofs with already used jti when set
+	Replay *DPoPReplayCache
+	// PublicURL is scheme and host of service as seen by clients, taken from request when empty
+	PublicURL string
+}
+
+// Nonce returns nonce clients must put to proofs, empty when nonces are not required
+func (v DPoPValidator) Nonce() string {
+	if v.Nonces == nil {
+		return ""
+	}
+
+	return v.Nonces.Nonce(v.Time.Now())
+}
+
+// requestURI returns URI of request without query and fragment as expected in "htu" claim
+func (v DPoPValidator) requestURI(r *http.Request) string {
+	base := v.PublicURL
+
+	if base == "" {
+		scheme := "http"
+
+		if r.TLS != nil {
+			scheme = "https"
+		}
+
+		base = scheme + "://" + r.Host
+	}
+
+	return strings.TrimSuffix(base, "/") + r.URL.Path
+}
+
+// normalizeURI drops query and fragment and lowercases scheme and host
+func normalizeURI(uri string) (string, error) {
+	parsed, err := url.Parse(uri)
+
+	if err != nil {
+		return "", err
+	}
+
+	path := parsed.EscapedPath()
+
+	if path == "" {
+		path = "/"
+	}
+
+	return strings.ToLower(parsed.Scheme) + "://" + strings.ToLower(parsed.Host) + path, nil
+}
+
+// Validate checks DPoP proof of request: signature, method, URI, freshness, nonce and replay
+// accessToken is raw Access token proof must be bound to with "ath", empty for token endpoint requests
+// jkt is expected thumbprint of proof key, proof with any key is accepted when empty
+func (v DPoPValidator) Validate(r *http.Request, accessToken string, jkt string) (DPoPProof, error) {
+	values := r.Header.Values(DPoPHeader)
+
+	if len(values) != 1 {
+		return DPoPProof{}, invalidDPoPProof("expected one %v header, got %v", DPoPHeader, len(values))
+	}
+
+	proof, err := ParseDPoPProof(values[0])
+
+	if err != nil {
+		return DPoPProof{}, err
+	}
+
+	claims := proof.Claims
+
+	if claims.Method != r.Method {
+		return DPoPProof{}, invalidDPoPProof("htm %v does not match request method", claims.Method)
+	}
+
+	htu, err := normalizeURI(claims.URI)
+
+	if err != nil {
+		return DPoPProof{}, invalidDPoPProof("malformed htu")
+	}
+
+	expectedURI, err := normalizeURI(v.requestURI(r))
+
+	if err != nil || htu != expectedURI {
+		return DPoPProof{}, invalidDPoPProof("htu %v does not match request URI", claims.URI)
+	}
+
+	maxAge := v.MaxAge
+
+	if maxAge == 0 {
+		maxAge = DefaultDPoPProofMaxAge
+	}
+
+	now := v.Time.Now()
+	issuedAt := time.Unix(claims.IssuedAt, 0)
+
+	if issuedAt.After(now.Add(v.Time.Leeway)) || issuedAt.Add(maxAge+v.Time.Leeway).Before(now) {
+		return DPoPProof{}, invalidDPoPProof("proof is not fresh")
+	}
+
+	if accessToken != "" && !hmac.Equal([]byte(claims.AccessTokenHash), []byte(AccessTokenHash(accessToken))) {
+		return DPoPProof{}, invalidDPoPProof("ath does not match Access token")
+	}
+
+	if jkt != "" && proof.Thumbprint != jkt {
+		return DPoPProof{}, invalidDPoPProof("proof key does not match bound key")
+	}
+
+	if v.Nonces != nil && !v.Nonces.Valid(claims.Nonce, now) {
+		return DPoPProof{}, ErrUseDPoPNonce
+	}
+
+	if v.Replay != nil && !v.Replay.Use(proof.Thumbprint+":"+claims.ID, issuedAt.Add(maxAge+v.Time.Leeway), now) {
+		return DPoPProof{}, invalidDPoPProof("proof was already used")
+	}
+
+	return proof, nil
+}
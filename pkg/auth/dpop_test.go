@@ -0,0 +1,371 @@
+package auth
+
+import (
+	api "authservice/pkg/api"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestDPoPSigner(t *testing.T) Signer {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := NewSigner(key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signer
+}
+
+func newTestDPoPProof(t *testing.T, signer Signer, method string, uri string, accessToken string, nonce string, now time.Time) string {
+	t.Helper()
+
+	proof, err := NewDPoPProof(signer, method, uri, accessToken, nonce, now)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return proof
+}
+
+func TestDPoPValidator(t *testing.T) {
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	clock := ClockFunc(func() time.Time { return now })
+
+	signer := newTestDPoPSigner(t)
+	another := newTestDPoPSigner(t)
+
+	jkt, err := KeyID(signer)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const uri = "https://auth.example.com/v1/refresh"
+
+	tests := []struct {
+		Name        string
+		Proof       func() string
+		AccessToken string
+		JKT         string
+		Err         error
+	}{
+		{Name: "Ok", Proof: func() string { return newTestDPoPProof(t, signer, http.MethodPost, uri, "", "", now) }, JKT: jkt},
+		{Name: "Query is ignored", Proof: func() string { return newTestDPoPProof(t, signer, http.MethodPost, uri+"?a=b", "", "", now) }},
+		{Name: "Bound to Access token", Proof: func() string { return newTestDPoPProof(t, signer, http.MethodPost, uri, "token", "", now) }, AccessToken: "token"},
+		{Name: "Another Access token", Proof: func() string { return newTestDPoPProof(t, signer, http.MethodPost, uri, "another", "", now) }, AccessToken: "token", Err: ErrInvalidDPoPProof},
+		{Name: "Another key", Proof: func() string { return newTestDPoPProof(t, another, http.MethodPost, uri, "", "", now) }, JKT: jkt, Err: ErrInvalidDPoPProof},
+		{Name: "Another method", Proof: func() string { return newTestDPoPProof(t, signer, http.MethodGet, uri, "", "", now) }, Err: ErrInvalidDPoPProof},
+		{Name: "Another URI", Proof: func() string {
+			return newTestDPoPProof(t, signer, http.MethodPost, "https://auth.example.com/v1/auth", "", "", now)
+		}, Err: ErrInvalidDPoPProof},
+		{Name: "Stale", Proof: func() string {
+			return newTestDPoPProof(t, signer, http.MethodPost, uri, "", "", now.Add(-DefaultDPoPProofMaxAge-time.Second))
+		}, Err: ErrInvalidDPoPProof},
+		{Name: "Issued in future", Proof: func() string { return newTestDPoPProof(t, signer, http.MethodPost, uri, "", "", now.Add(time.Minute)) }, Err: ErrInvalidDPoPProof},
+		{Name: "Tampered", Proof: func() string {
+			proof := newTestDPoPProof(t, signer, http.MethodPost, uri, "", "", now)
+			parts := strings.Split(proof, ".")
+			anotherProof := newTestDPoPProof(t, signer, http.MethodPost, "https://auth.example.com/v1/auth", "", "", now)
+			return parts[0] + "." + strings.Split(anotherProof, ".")[1] + "." + parts[2]
+		}, Err: ErrInvalidDPoPProof},
+		{Name: "Malformed", Proof: func() string { return "proof" }, Err: ErrInvalidDPoPProof},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			validator := DPoPValidator{
+				Time:      TimeValidator{Clock: clock},
+				Replay:    NewDPoPReplayCache(),
+				PublicURL: "https://auth.example.com",
+			}
+
+			request := httptest.NewRequest(http.MethodPost, "/v1/refresh", nil)
+			request.Header.Set(DPoPHeader, test.Proof())
+
+			proof, err := validator.Validate(request, test.AccessToken, test.JKT)
+
+			if test.Err == nil {
+				if err != nil {
+					t.Fatalf("failed to validate proof: %v", err)
+				}
+
+				if proof.Thumbprint != jkt {
+					t.Fatalf("unexpected proof thumbprint: %v", proof.Thumbprint)
+				}
+
+				// Same proof can not be used twice
+				if _, err := validator.Validate(request, test.AccessToken, test.JKT); !errors.Is(err, ErrInvalidDPoPProof) {
+					t.Fatalf("replayed proof was accepted: %v", err)
+				}
+			} else if !errors.Is(err, test.Err) {
+				t.Fatalf("expected error %v, got %v", test.Err, err)
+			}
+		})
+	}
+}
+
+func TestDPoPValidatorRequestURI(t *testing.T) {
+	signer := newTestDPoPSigner(t)
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	validator := DPoPValidator{Time: TimeValidator{Clock: ClockFunc(func() time.Time { return now })}}
+
+	request := httptest.NewRequest(http.MethodGet, "http://Auth.Example.com/v1/sessions", nil)
+	request.Header.Set(DPoPHeader, newTestDPoPProof(t, signer, http.MethodGet, "http://auth.example.com/v1/sessions", "", "", now))
+
+	if _, err := validator.Validate(request, "", ""); err != nil {
+		t.Fatalf("htu was not matched with request host: %v", err)
+	}
+
+	request.Header.Add(DPoPHeader, newTestDPoPProof(t, signer, http.MethodGet, "http://auth.example.com/v1/sessions", "", "", now))
+
+	if _, err := validator.Validate(request, "", ""); !errors.Is(err, ErrInvalidDPoPProof) {
+		t.Fatalf("request with two proofs was accepted: %v", err)
+	}
+}
+
+func TestDPoPNonces(t *testing.T) {
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	nonces := NewDPoPNonces([]byte("nonce secret"), time.Minute)
+	nonce := nonces.Nonce(now)
+
+	if !nonces.Valid(nonce, now) || !nonces.Valid(nonce, now.Add(time.Minute)) {
+		t.Fatalf("nonce is not valid during its and next window")
+	}
+
+	if nonces.Valid(nonce, now.Add(2*time.Minute)) {
+		t.Fatalf("nonce is valid after next window")
+	}
+
+	if nonces.Valid(nonce, now.Add(-time.Minute)) {
+		t.Fatalf("nonce is valid before it was issued")
+	}
+
+	if NewDPoPNonces([]byte("another secret"), time.Minute).Valid(nonce, now) {
+		t.Fatalf("nonce is valid with another secret")
+	}
+
+	if nonces.Valid("", now) || nonces.Valid("nonce", now) {
+		t.Fatalf("malformed nonce is valid")
+	}
+
+	signer := newTestDPoPSigner(t)
+
+	validator := DPoPValidator{
+		Time:   TimeValidator{Clock: ClockFunc(func() time.Time { return now })},
+		Nonces: nonces,
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "http://auth.example.com/v1/auth", nil)
+	request.Header.Set(DPoPHeader, newTestDPoPProof(t, signer, http.MethodPost, "http://auth.example.com/v1/auth", "", "", now))
+
+	if _, err := validator.Validate(request, "", ""); !errors.Is(err, ErrUseDPoPNonce) {
+		t.Fatalf("expected ErrUseDPoPNonce for proof without nonce, got: %v", err)
+	}
+
+	request.Header.Set(DPoPHeader, newTestDPoPProof(t, signer, http.MethodPost, "http://auth.example.com/v1/auth", "", validator.Nonce(), now))
+
+	if _, err := validator.Validate(request, "", ""); err != nil {
+		t.Fatalf("failed to validate proof with nonce: %v", err)
+	}
+}
+
+func TestParseDPoPProofHeader(t *testing.T) {
+	signer := newTestDPoPSigner(t)
+
+	jwk, err := NewJWK("", signer)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hmacSigner, err := NewHMACSigner(AlgHS256, []byte("secret"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := `{"jti":"id","htm":"POST","htu":"http://auth.example.com/v1/auth","iat":1700000000}`
+
+	sign := func(signer Signer, header string) string {
+		input := base64.RawURLEncoding.EncodeToString([]byte(header)) + "." + base64.RawURLEncoding.EncodeToString([]byte(claims))
+
+		signature, err := signer.Sign([]byte(input))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+	}
+
+	jwkJson, err := json.Marshal(jwk)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	private := strings.TrimSuffix(string(jwkJson), "}") + `,"d":"private"}`
+
+	tests := map[string]string{
+		"Ok":                 sign(signer, `{"typ":"dpop+jwt","alg":"ES256","jwk":`+string(jwkJson)+`}`),
+		"Wrong typ":          sign(signer, `{"typ":"JWT","alg":"ES256","jwk":`+string(jwkJson)+`}`),
+		"No jwk":             sign(signer, `{"typ":"dpop+jwt","alg":"ES256"}`),
+		"Private jwk":        sign(signer, `{"typ":"dpop+jwt","alg":"ES256","jwk":`+private+`}`),
+		"Alg of another key": sign(signer, `{"typ":"dpop+jwt","alg":"EdDSA","jwk":`+string(jwkJson)+`}`),
+		"Symmetric":          sign(hmacSigner, `{"typ":"dpop+jwt","alg":"HS256","jwk":{"kty":"oct","k":"c2VjcmV0"}}`),
+	}
+
+	for name, proof := range tests {
+		_, err := ParseDPoPProof(proof)
+
+		if name == "Ok" {
+			if err != nil {
+				t.Fatalf("failed to parse proof: %v", err)
+			}
+			continue
+		}
+
+		if !errors.Is(err, ErrInvalidDPoPProof) {
+			t.Errorf("%v: expected ErrInvalidDPoPProof, got %v", name, err)
+		}
+	}
+}
+
+func TestMiddlewareDPoP(t *testing.T) {
+	keySigner, err := NewHMACSigner(AlgHS256, []byte("secret"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	timeValidator := TimeValidator{Clock: ClockFunc(func() time.Time { return now })}
+
+	signer := newTestDPoPSigner(t)
+	another := newTestDPoPSigner(t)
+
+	jkt, err := KeyID(signer)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifier := TokenVerifier{
+		Keys: NewKeyring("kid", keySigner),
+		Time: timeValidator,
+		DPoP: &DPoPValidator{
+			Time:   timeValidator,
+			Replay: NewDPoPReplayCache(),
+			Nonces: NewDPoPNonces([]byte("nonce secret"), time.Minute),
+		},
+	}
+
+	bound := newTestToken(now)
+	bound.Payload.Confirmation = &api.Confirmation{JKT: jkt}
+
+	if err := SignAccessTokenCompactWith(&bound, keySigner); err != nil {
+		t.Fatal(err)
+	}
+
+	bearer := newTestToken(now)
+
+	if err := SignAccessTokenCompactWith(&bearer, keySigner); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := verifier.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	const uri = "http://api.example.com/resource"
+	nonce := verifier.DPoP.Nonce()
+
+	tests := []struct {
+		Name          string
+		Authorization string
+		Proof         string
+		Code          int
+		Nonce         bool
+	}{
+		{Name: "Ok", Authorization: "DPoP " + bound.Raw, Proof: newTestDPoPProof(t, signer, http.MethodGet, uri, bound.Raw, nonce, now), Code: http.StatusOK},
+		{Name: "Bearer token", Authorization: "Bearer " + bearer.Raw, Code: http.StatusOK},
+		{Name: "Bound token as bearer", Authorization: "Bearer " + bound.Raw, Proof: newTestDPoPProof(t, signer, http.MethodGet, uri, bound.Raw, nonce, now), Code: http.StatusUnauthorized},
+		{Name: "No proof", Authorization: "DPoP " + bound.Raw, Code: http.StatusUnauthorized},
+		{Name: "Another key", Authorization: "DPoP " + bound.Raw, Proof: newTestDPoPProof(t, another, http.MethodGet, uri, bound.Raw, nonce, now), Code: http.StatusUnauthorized},
+		{Name: "Proof of another token", Authorization: "DPoP " + bound.Raw, Proof: newTestDPoPProof(t, signer, http.MethodGet, uri, bearer.Raw, nonce, now), Code: http.StatusUnauthorized},
+		{Name: "No nonce", Authorization: "DPoP " + bound.Raw, Proof: newTestDPoPProof(t, signer, http.MethodGet, uri, bound.Raw, "", now), Code: http.StatusUnauthorized, Nonce: true},
+		{Name: "Unbound token with DPoP scheme", Authorization: "DPoP " + bearer.Raw, Proof: newTestDPoPProof(t, signer, http.MethodGet, uri, bearer.Raw, nonce, now), Code: http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, uri, nil)
+			request.Header.Set("Authorization", test.Authorization)
+
+			if test.Proof != "" {
+				request.Header.Set(DPoPHeader, test.Proof)
+			}
+
+			recorder := httptest.NewRecorder()
+
+			handler.ServeHTTP(recorder, request)
+
+			if recorder.Code != test.Code {
+				t.Fatalf("expected status %v, got %v: %v", test.Code, recorder.Code, recorder.Header().Get("WWW-Authenticate"))
+			}
+
+			if test.Nonce != (recorder.Header().Get(DPoPNonceHeader) != "") {
+				t.Fatalf("unexpected %v header: %q", DPoPNonceHeader, recorder.Header().Get(DPoPNonceHeader))
+			}
+		})
+	}
+}
+
+func TestDPoPReplayCache(t *testing.T) {
+	cache := NewDPoPReplayCache()
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	if !cache.Use("first", now.Add(time.Minute), now) {
+		t.Fatalf("new proof was rejected")
+	}
+
+	if !cache.Use("second", now.Add(2*time.Minute), now) {
+		t.Fatalf("new proof was rejected")
+	}
+
+	if cache.Use("first", now.Add(time.Minute), now.Add(30*time.Second)) {
+		t.Fatalf("replayed proof was accepted")
+	}
+
+	now = now.Add(90 * time.Second)
+
+	if !cache.Use("third", now.Add(time.Minute), now) {
+		t.Fatalf("new proof was rejected")
+	}
+
+	if _, ok := cache.seen["first"]; ok || len(cache.queue) != 2 {
+		t.Fatalf("expired proof was not dropped: %v %v", cache.seen, len(cache.queue))
+	}
+
+	// Proof reused after its expiry is remembered again
+	if !cache.Use("first", now.Add(time.Minute), now) || cache.Use("first", now.Add(time.Minute), now) {
+		t.Fatalf("expired proof key was not reusable once")
+	}
+}
@@ -0,0 +1,203 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+)
+
+// KeyUseSignature is "use" parameter of keys verifying Access tokens
+const KeyUseSignature string = "sig"
+
+// minRSAKeySize is minimal size of RSA keys decoded from JWK or loaded for signing and verification
+const minRSAKeySize int = 2048
+
+// JWK is public JSON Web Key as defined by RFC 7517
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Use string `json:"use,omitempty"`
+	// RSA public key parameters
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// EC and OKP public key parameters
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKSet is set of public keys served from JWKS endpoint
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewJWK creates public JWK for verifier backed by public key
+// HMAC verifiers are not supported since they hold secret
+func NewJWK(kid string, verifier Verifier) (JWK, error) {
+	publicVerifier, ok := verifier.(PublicKeyVerifier)
+
+	if !ok {
+		return JWK{}, fmt.Errorf("%w: %v key can not be published", ErrUnsupportedKey, verifier.Alg())
+	}
+
+	var jwk JWK
+
+	switch key := publicVerifier.PublicKey().(type) {
+	case *rsa.PublicKey:
+		jwk = JWK{
+			Kty: "RSA",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		jwk = JWK{
+			Kty: "EC",
+			Crv: key.Curve.Params().Name,
+			X:   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, size))),
+			Y:   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, size))),
+		}
+	case ed25519.PublicKey:
+		jwk = JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(key),
+		}
+	default:
+		return JWK{}, fmt.Errorf("%w: %T", ErrUnsupportedKey, key)
+	}
+
+	jwk.Kid = kid
+	jwk.Alg = verifier.Alg()
+	jwk.Use = KeyUseSignature
+
+	return jwk, nil
+}
+
+// Thumbprint calculates base64url encoded SHA-256 JWK thumbprint as defined by RFC 7638
+func (k JWK) Thumbprint() (string, error) {
+	// Required members in lexicographic order, encoding/json keeps struct field order
+	var members any
+
+	switch k.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{k.E, k.Kty, k.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{k.Crv, k.Kty, k.X, k.Y}
+	case "OKP":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{k.Crv, k.Kty, k.X}
+	default:
+		return "", fmt.Errorf("%w: kty %v", ErrUnsupportedKey, k.Kty)
+	}
+
+	data, err := json.Marshal(members)
+
+	if err != nil {
+		return "", fmt.Errorf("failed to marshall JWK thumbprint members: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// PublicKey decodes public key of JWK, supported keys are RSA, EC P-256 and Ed25519
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+
+		if err != nil || len(n) == 0 {
+			return nil, fmt.Errorf("%w: invalid RSA modulus", ErrUnsupportedKey)
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("%w: invalid RSA exponent", ErrUnsupportedKey)
+		}
+
+		key := &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+
+		if key.N.BitLen() < minRSAKeySize {
+			return nil, fmt.Errorf("%w: RSA key of %v bits", ErrUnsupportedKey, key.N.BitLen())
+		}
+
+		return key, nil
+	case "EC":
+		if k.Crv != elliptic.P256().Params().Name {
+			return nil, fmt.Errorf("%w: EC curve %v", ErrUnsupportedKey, k.Crv)
+		}
+
+		x, errX := base64.RawURLEncoding.DecodeString(k.X)
+		y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+
+		if errX != nil || errY != nil || len(x) != es256CoordinateSize || len(y) != es256CoordinateSize {
+			return nil, fmt.Errorf("%w: invalid EC coordinates", ErrUnsupportedKey)
+		}
+
+		// ecdh checks that point is on curve
+		point := append(append([]byte{4}, x...), y...)
+
+		if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrUnsupportedKey, err)
+		}
+
+		return &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+
+		if k.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("%w: invalid OKP key", ErrUnsupportedKey)
+		}
+
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("%w: kty %v", ErrUnsupportedKey, k.Kty)
+	}
+}
+
+// KeyID returns "kid" of the key: RFC 7638 thumbprint for public keys
+// and fingerprint derived with HMAC for shared secrets
+func KeyID(verifier Verifier) (string, error) {
+	if hmacSigner, ok := verifier.(*HMACSigner); ok {
+		return hmacSigner.keyID(), nil
+	}
+
+	jwk, err := NewJWK("", verifier)
+
+	if err != nil {
+		return "", err
+	}
+
+	return jwk.Thumbprint()
+}
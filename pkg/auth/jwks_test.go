@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+func TestJWKThumbprint(t *testing.T) {
+	// Example from RFC 7638 section 3.1
+	jwk := JWK{
+		Kty: "RSA",
+		N:   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		E:   "AQAB",
+		Alg: "RS256",
+		Kid: "2011-04-29",
+	}
+
+	thumbprint, err := jwk.Thumbprint()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if thumbprint != "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs" {
+		t.Fatalf("unexpected thumbprint: %v", thumbprint)
+	}
+}
+
+func TestNewJWK(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []any{ecKey, edKey} {
+		signer, err := NewSigner(key)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		jwk, err := NewJWK("kid", signer)
+
+		if err != nil {
+			t.Fatalf("failed to create JWK for %v key: %v", signer.Alg(), err)
+		}
+
+		if jwk.Kid != "kid" || jwk.Alg != signer.Alg() || jwk.Use != KeyUseSignature || jwk.X == "" {
+			t.Fatalf("incorrect JWK for %v key: %#v", signer.Alg(), jwk)
+		}
+
+		kid, err := KeyID(signer)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		thumbprint, err := jwk.Thumbprint()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if kid != thumbprint {
+			t.Fatalf("key id is not equal to JWK thumbprint: %v %v", kid, thumbprint)
+		}
+	}
+
+	hmacSigner, err := NewHMACSigner(AlgHS256, []byte("secret"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewJWK("kid", hmacSigner); !errors.Is(err, ErrUnsupportedKey) {
+		t.Fatalf("HMAC secret must not be published as JWK")
+	}
+}
+
+func TestJWKPublicKey(t *testing.T) {
+	for alg, pair := range generateTestKeys(t) {
+		signer, err := LoadSignerFromPEM(pair[0])
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		jwk, err := NewJWK("kid", signer)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		publicKey, err := jwk.PublicKey()
+
+		if err != nil {
+			t.Fatalf("failed to decode %v JWK: %v", alg, err)
+		}
+
+		verifier, err := NewVerifier(publicKey)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		signature, err := signer.Sign([]byte("data"))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = verifier.Verify([]byte("data"), signature); err != nil {
+			t.Fatalf("decoded %v JWK does not verify signature: %v", alg, err)
+		}
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := NewSigner(ecKey)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	offCurve, err := NewJWK("", signer)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	offCurve.Y = offCurve.X
+
+	shortRSA := JWK{Kty: "RSA", N: "AQAB", E: "AQAB"}
+
+	for _, jwk := range []JWK{offCurve, shortRSA, {Kty: "oct"}, {Kty: "OKP", Crv: "X25519", X: offCurve.X}} {
+		if _, err := jwk.PublicKey(); !errors.Is(err, ErrUnsupportedKey) {
+			t.Errorf("invalid JWK %#v was decoded: %v", jwk, err)
+		}
+	}
+}
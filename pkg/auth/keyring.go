@@ -0,0 +1,253 @@
+package auth
+
+import (
+	api "authservice/pkg/api"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrUnknownKey error = errors.New("unknown Access token signing key")
+var ErrKeyExists error = errors.New("signing key with same kid already exists")
+
+// keyringEntry is verify-only key of Keyring
+type keyringEntry struct {
+	verifier Verifier
+	// retiresAt is moment after which key stops verifying Access tokens, zero means key is kept until removed explicitly
+	retiresAt time.Time
+	// refreshUntil is moment until which retired key still verifies Access tokens presented for refresh
+	refreshUntil time.Time
+}
+
+// Keyring holds one active signing key and several verify-only keys identified by kid
+// It is safe for concurrent use
+type Keyring struct {
+	mu       sync.RWMutex
+	activeID string
+	active   Signer
+	keys     map[string]keyringEntry
+	clock    Clock
+}
+
+// NewKeyring creates Keyring with active signing key
+func NewKeyring(kid string, signer Signer) *Keyring {
+	return &Keyring{
+		activeID: kid,
+		active:   signer,
+		keys:     map[string]keyringEntry{},
+		clock:    SystemClock{},
+	}
+}
+
+// Active returns kid and signer of active signing key
+func (k *Keyring) Active() (string, Signer) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	return k.activeID, k.active
+}
+
+// AddVerifier adds verify-only key which stops verifying Access tokens after retiresAt
+// and Access tokens presented for refresh after refreshUntil, key is removed after both
+// Zero retiresAt keeps key until it is replaced
+func (k *Keyring) AddVerifier(kid string, verifier Verifier, retiresAt time.Time, refreshUntil time.Time) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if kid == k.activeID {
+		return fmt.Errorf("%w: %v", ErrKeyExists, kid)
+	}
+
+	k.keys[kid] = keyringEntry{
+		verifier:     verifier,
+		retiresAt:    retiresAt,
+		refreshUntil: refreshUntil,
+	}
+
+	return nil
+}
+
+// Rotate makes signer active signing key
+// Previous active key stays verify-only for overlap, so tokens it signed keep verifying until they expire,
+// and verifies Access tokens presented for refresh for refreshOverlap, so idle clients keep their sessions
+func (k *Keyring) Rotate(kid string, signer Signer, overlap time.Duration, refreshOverlap time.Duration) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if kid == k.activeID {
+		return fmt.Errorf("%w: %v", ErrKeyExists, kid)
+	}
+
+	k.purge()
+
+	delete(k.keys, kid)
+
+	now := k.clock.Now()
+
+	k.keys[k.activeID] = keyringEntry{
+		verifier:     k.active,
+		retiresAt:    now.Add(overlap),
+		refreshUntil: now.Add(refreshOverlap),
+	}
+
+	k.activeID = kid
+	k.active = signer
+
+	return nil
+}
+
+// purge removes keys retired for both Access tokens and refresh, must be called with write lock held
+func (k *Keyring) purge() {
+	now := k.clock.Now()
+
+	for kid, entry := range k.keys {
+		if entry.refreshRetired(now) {
+			delete(k.keys, kid)
+		}
+	}
+}
+
+func (e keyringEntry) retired(now time.Time) bool {
+	return !e.retiresAt.IsZero() && now.After(e.retiresAt)
+}
+
+// refreshRetired reports whether key stopped verifying Access tokens presented for refresh
+// refreshUntil before retiresAt has no effect
+func (e keyringEntry) refreshRetired(now time.Time) bool {
+	return e.retired(now) && now.After(e.refreshUntil)
+}
+
+// Verifier returns verifier of active or not yet retired key by kid
+// Empty kid refers to active key since tokens issued before kid was introduced have none
+func (k *Keyring) Verifier(kid string) (Verifier, error) {
+	return k.verifier(kid, false)
+}
+
+// verifier returns verifier of key by kid, for refresh keys retired for Access tokens are used until refreshUntil
+func (k *Keyring) verifier(kid string, refresh bool) (Verifier, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if kid == "" || kid == k.activeID {
+		return k.active, nil
+	}
+
+	entry, ok := k.keys[kid]
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrUnknownKey, kid)
+	}
+
+	now := k.clock.Now()
+
+	if (!refresh && entry.retired(now)) || (refresh && entry.refreshRetired(now)) {
+		return nil, fmt.Errorf("%w: %v", ErrUnknownKey, kid)
+	}
+
+	return entry.verifier, nil
+}
+
+// KeyIDs returns kids of active and not yet retired keys, active key goes first
+func (k *Keyring) KeyIDs() []string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	now := k.clock.Now()
+	ids := []string{}
+
+	for kid, entry := range k.keys {
+		if !entry.retired(now) {
+			ids = append(ids, kid)
+		}
+	}
+
+	sort.Strings(ids)
+
+	return append([]string{k.activeID}, ids...)
+}
+
+// SignAccessToken sets kid of active key and signs token in compact or legacy format
+func (k *Keyring) SignAccessToken(token *api.AccessToken, compact bool) error {
+	if token == nil {
+		return ErrNilPointerToken
+	}
+
+	kid, signer := k.Active()
+
+	token.Header.Kid = kid
+
+	if compact {
+		return SignAccessTokenCompactWith(token, signer)
+	}
+
+	return SignAccessTokenWith(token, signer)
+}
+
+// VerifyAccessToken checks token signature with key referenced by token kid
+func (k *Keyring) VerifyAccessToken(token api.AccessToken) error {
+	verifier, err := k.Verifier(token.Header.Kid)
+
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+
+	return VerifyAccessTokenWith(token, verifier)
+}
+
+// VerifyRefreshedAccessToken checks signature of Access token presented with Refresh token
+// Keys retired for Access tokens keep verifying it until their refreshUntil
+func (k *Keyring) VerifyRefreshedAccessToken(token api.AccessToken) error {
+	verifier, err := k.verifier(token.Header.Kid, true)
+
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+
+	return VerifyAccessTokenWith(token, verifier)
+}
+
+// ParseAccessToken parses compact serialization of access token and verifies it with key referenced by token kid
+func (k *Keyring) ParseAccessToken(s string) (api.AccessToken, error) {
+	token, err := api.ParseCompactAccessToken(s)
+
+	if err != nil {
+		return api.AccessToken{}, err
+	}
+
+	if err = k.VerifyAccessToken(token); err != nil {
+		return api.AccessToken{}, err
+	}
+
+	return token, nil
+}
+
+// JWKSet returns public keys of active and not yet retired keys
+// HMAC keys are skipped since they can not be published
+func (k *Keyring) JWKSet() (JWKSet, error) {
+	set := JWKSet{Keys: []JWK{}}
+
+	for _, kid := range k.KeyIDs() {
+		verifier, err := k.Verifier(kid)
+
+		if err != nil {
+			// key was retired between calls
+			continue
+		}
+
+		if _, ok := verifier.(PublicKeyVerifier); !ok {
+			continue
+		}
+
+		jwk, err := NewJWK(kid, verifier)
+
+		if err != nil {
+			return JWKSet{}, err
+		}
+
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	return set, nil
+}
@@ -0,0 +1,163 @@
+package auth
+
+import (
+	api "authservice/pkg/api"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestKeyringRotation(t *testing.T) {
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	oldSigner, err := NewHMACSigner(AlgHS512, []byte("old secret"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldID, err := KeyID(oldSigner)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newSigner, err := NewSigner(key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newID, err := KeyID(newSigner)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyring := NewKeyring(oldID, oldSigner)
+	keyring.clock = ClockFunc(func() time.Time { return now })
+
+	oldToken := api.NewAccessToken(now.Add(time.Hour), "session")
+
+	if err := keyring.SignAccessToken(&oldToken, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if oldToken.Header.Kid != oldID {
+		t.Fatalf("token kid %v is not active key id %v", oldToken.Header.Kid, oldID)
+	}
+
+	if err := keyring.Rotate(newID, newSigner, time.Hour, 24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := keyring.Rotate(newID, newSigner, time.Hour, 24*time.Hour); !errors.Is(err, ErrKeyExists) {
+		t.Fatalf("active key was rotated to itself")
+	}
+
+	if kid, _ := keyring.Active(); kid != newID {
+		t.Fatalf("active key was not rotated")
+	}
+
+	newToken := api.NewAccessToken(now.Add(time.Hour), "session")
+
+	if err := keyring.SignAccessToken(&newToken, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := keyring.ParseAccessToken(newToken.Raw); err != nil {
+		t.Fatalf("failed to verify token signed with new key: %v", err)
+	}
+
+	if _, err := keyring.ParseAccessToken(oldToken.Raw); err != nil {
+		t.Fatalf("failed to verify token signed with old key during overlap: %v", err)
+	}
+
+	set, err := keyring.JWKSet()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(set.Keys) != 1 || set.Keys[0].Kid != newID {
+		t.Fatalf("only public key must be published: %#v", set)
+	}
+
+	now = now.Add(time.Hour + time.Second)
+
+	if _, err := keyring.ParseAccessToken(oldToken.Raw); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("token signed with retired key was verified")
+	}
+
+	if _, err := keyring.ParseAccessToken(newToken.Raw); err != nil {
+		t.Fatalf("failed to verify token signed with new key: %v", err)
+	}
+
+	if ids := keyring.KeyIDs(); len(ids) != 1 || ids[0] != newID {
+		t.Fatalf("retired key is still listed: %v", ids)
+	}
+
+	// Client idle longer than Access token lifetime still refreshes its session
+	if err := keyring.VerifyRefreshedAccessToken(oldToken); err != nil {
+		t.Fatalf("failed to verify token presented for refresh after Access token overlap: %v", err)
+	}
+
+	now = now.Add(24 * time.Hour)
+
+	if err := keyring.VerifyRefreshedAccessToken(oldToken); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("token signed with key retired for refresh was verified")
+	}
+}
+
+func TestKeyringAddVerifier(t *testing.T) {
+	signer, err := NewHMACSigner(AlgHS256, []byte("secret"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifyOnly, err := NewHMACSigner(AlgHS256, []byte("another secret"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyring := NewKeyring("active", signer)
+
+	if err := keyring.AddVerifier("active", verifyOnly, time.Time{}, time.Time{}); !errors.Is(err, ErrKeyExists) {
+		t.Fatalf("verify-only key replaced active key")
+	}
+
+	if err := keyring.AddVerifier("verify-only", verifyOnly, time.Time{}, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	token := api.NewAccessToken(time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC), "session")
+	token.Header.Kid = "verify-only"
+
+	if err := SignAccessTokenCompactWith(&token, verifyOnly); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := keyring.VerifyAccessToken(token); err != nil {
+		t.Fatalf("failed to verify token with verify-only key: %v", err)
+	}
+
+	token.Header.Kid = "unknown"
+
+	if err := SignAccessTokenCompactWith(&token, verifyOnly); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := keyring.VerifyAccessToken(token); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("token with unknown kid was verified")
+	}
+}
@@ -0,0 +1,160 @@
+package auth
+
+import (
+	api "authservice/pkg/api"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var ErrNoBearerToken error = errors.New("no bearer token in request")
+
+// contextKey is type of request context keys set by middleware
+type contextKey int
+
+const accessTokenContextKey contextKey = iota
+
+// BearerToken extracts token from Authorization header as defined by RFC 6750
+func BearerToken(r *http.Request) (string, error) {
+	scheme, token, err := AuthorizationToken(r)
+
+	if err != nil || scheme != "Bearer" {
+		return "", ErrNoBearerToken
+	}
+
+	return token, nil
+}
+
+// AuthorizationToken extracts token from Authorization header with Bearer or DPoP scheme
+// Returned scheme is either "Bearer" or DPoPScheme
+func AuthorizationToken(r *http.Request) (scheme string, token string, err error) {
+	header := r.Header.Get("Authorization")
+
+	scheme, token, found := strings.Cut(header, " ")
+
+	if !found {
+		return "", "", ErrNoBearerToken
+	}
+
+	switch {
+	case strings.EqualFold(scheme, "Bearer"):
+		scheme = "Bearer"
+	case strings.EqualFold(scheme, DPoPScheme):
+		scheme = DPoPScheme
+	default:
+		return "", "", ErrNoBearerToken
+	}
+
+	token = strings.TrimSpace(token)
+
+	if token == "" {
+		return "", "", ErrNoBearerToken
+	}
+
+	return scheme, token, nil
+}
+
+// checkProofOfPossession requires DPoP scheme and valid proof for DPoP bound tokens
+// Bound token presented as bearer token is rejected, so stolen token can not be downgraded
+func (v TokenVerifier) checkProofOfPossession(r *http.Request, scheme string, raw string, token api.AccessToken) error {
+	jkt := ""
+
+	if token.Payload.Confirmation != nil {
+		jkt = token.Payload.Confirmation.JKT
+	}
+
+	if jkt == "" {
+		if scheme == DPoPScheme {
+			return invalidDPoPProof("Access token is not DPoP bound")
+		}
+		return nil
+	}
+
+	if scheme != DPoPScheme {
+		return invalidDPoPProof("DPoP bound Access token is passed as bearer token")
+	}
+
+	if v.DPoP == nil {
+		return invalidDPoPProof("DPoP proofs are not accepted")
+	}
+
+	_, err := v.DPoP.Validate(r, raw, jkt)
+
+	return err
+}
+
+// Middleware verifies bearer Access token and places validated token into request context
+// Requests without valid token are rejected with 401 and WWW-Authenticate header
+func (v TokenVerifier) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scheme, raw, err := AuthorizationToken(r)
+
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		token, err := v.Parse(raw)
+
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf("Bearer error=\"invalid_token\", error_description=%q", err.Error()))
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("invalid Access token"))
+			return
+		}
+
+		if err = CheckCertificateBinding(r, token); err != nil {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf("Bearer error=\"invalid_token\", error_description=%q", err.Error()))
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("invalid Access token"))
+			return
+		}
+
+		if err = v.checkProofOfPossession(r, scheme, raw, token); err != nil {
+			if errors.Is(err, ErrUseDPoPNonce) {
+				w.Header().Set(DPoPNonceHeader, v.DPoP.Nonce())
+				w.Header().Set("WWW-Authenticate", `DPoP error="use_dpop_nonce", error_description="Resource server requires nonce in DPoP proof"`)
+			} else {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf("DPoP error=\"invalid_dpop_proof\", error_description=%q", err.Error()))
+			}
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("invalid DPoP proof"))
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(ContextWithAccessToken(r.Context(), token)))
+	})
+}
+
+// ContextWithAccessToken returns copy of ctx carrying validated Access token
+func ContextWithAccessToken(ctx context.Context, token api.AccessToken) context.Context {
+	return context.WithValue(ctx, accessTokenContextKey, token)
+}
+
+// AccessTokenFromContext returns Access token validated by Middleware
+func AccessTokenFromContext(ctx context.Context) (api.AccessToken, bool) {
+	token, ok := ctx.Value(accessTokenContextKey).(api.AccessToken)
+	return token, ok
+}
+
+// ClaimsFromContext returns claims of Access token validated by Middleware
+func ClaimsFromContext(ctx context.Context) (api.AccessTokenPayload, bool) {
+	token, ok := AccessTokenFromContext(ctx)
+	return token.Payload, ok
+}
+
+// SubjectFromContext returns GUID of user whose Access token was validated by Middleware
+func SubjectFromContext(ctx context.Context) (string, bool) {
+	token, ok := AccessTokenFromContext(ctx)
+	return token.Payload.Subject, ok
+}
+
+// SessionFromContext returns session of Access token validated by Middleware
+func SessionFromContext(ctx context.Context) (string, bool) {
+	token, ok := AccessTokenFromContext(ctx)
+	return token.Payload.Session, ok
+}
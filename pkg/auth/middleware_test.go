@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMiddleware(t *testing.T) {
+	signer, err := NewHMACSigner(AlgHS256, []byte("secret"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	verifier := TokenVerifier{
+		Keys:   NewKeyring("kid", signer),
+		Issuer: "issuer",
+		Time:   TimeValidator{Clock: ClockFunc(func() time.Time { return now })},
+	}
+
+	valid := newTestToken(now)
+
+	if err := SignAccessTokenCompactWith(&valid, signer); err != nil {
+		t.Fatal(err)
+	}
+
+	expired := newTestToken(now.Add(-2 * time.Hour))
+
+	if err := SignAccessTokenCompactWith(&expired, signer); err != nil {
+		t.Fatal(err)
+	}
+
+	var subject, session string
+
+	handler := verifier.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ok bool
+
+		if subject, ok = SubjectFromContext(r.Context()); !ok {
+			t.Errorf("no subject in request context")
+		}
+
+		if session, ok = SessionFromContext(r.Context()); !ok {
+			t.Errorf("no session in request context")
+		}
+	}))
+
+	tests := []struct {
+		Name          string
+		Authorization string
+		Code          int
+	}{
+		{Name: "Ok", Authorization: "Bearer " + valid.Raw, Code: http.StatusOK},
+		{Name: "Lowercase scheme", Authorization: "bearer " + valid.Raw, Code: http.StatusOK},
+		{Name: "No header", Authorization: "", Code: http.StatusUnauthorized},
+		{Name: "Basic", Authorization: "Basic dXNlcjpwYXNz", Code: http.StatusUnauthorized},
+		{Name: "Expired", Authorization: "Bearer " + expired.Raw, Code: http.StatusUnauthorized},
+		{Name: "Tampered", Authorization: "Bearer " + valid.Raw + "A", Code: http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			subject, session = "", ""
+
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+
+			if test.Authorization != "" {
+				request.Header.Set("Authorization", test.Authorization)
+			}
+
+			recorder := httptest.NewRecorder()
+
+			handler.ServeHTTP(recorder, request)
+
+			if recorder.Code != test.Code {
+				t.Fatalf("expected status %v, got %v", test.Code, recorder.Code)
+			}
+
+			if test.Code != http.StatusOK {
+				if recorder.Header().Get("WWW-Authenticate") == "" {
+					t.Fatalf("no WWW-Authenticate header in rejected response")
+				}
+				return
+			}
+
+			if subject != valid.Payload.Subject || session != valid.Payload.Session {
+				t.Fatalf("unexpected claims in context: %v %v", subject, session)
+			}
+		})
+	}
+}
+
+func TestClaimsFromEmptyContext(t *testing.T) {
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	if _, ok := ClaimsFromContext(request.Context()); ok {
+		t.Fatalf("claims found in empty context")
+	}
+}
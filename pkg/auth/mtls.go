@@ -0,0 +1,44 @@
+package auth
+
+import (
+	api "authservice/pkg/api"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"net/http"
+)
+
+// ErrCertificateMismatch is returned when certificate bound token is presented over connection with another client certificate
+var ErrCertificateMismatch error = errors.New("client certificate does not match certificate token is bound to")
+
+// CertificateThumbprint returns base64url encoded SHA-256 of DER certificate as in "x5t#S256" confirmation of RFC 8705
+func CertificateThumbprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// ClientCertificateThumbprint returns thumbprint of client certificate of TLS connection, empty when client presented none
+func ClientCertificateThumbprint(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return ""
+	}
+
+	return CertificateThumbprint(r.TLS.PeerCertificates[0])
+}
+
+// CheckCertificateBinding checks that token bound to client certificate is presented over connection with that certificate
+// Tokens without "x5t#S256" confirmation are accepted
+func CheckCertificateBinding(r *http.Request, token api.AccessToken) error {
+	if token.Payload.Confirmation == nil || token.Payload.Confirmation.X5TS256 == "" {
+		return nil
+	}
+
+	if !hmac.Equal([]byte(ClientCertificateThumbprint(r)), []byte(token.Payload.Confirmation.X5TS256)) {
+		return ErrCertificateMismatch
+	}
+
+	return nil
+}
@@ -0,0 +1,143 @@
+package auth
+
+import (
+	api "authservice/pkg/api"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestCertificate(t *testing.T, name string) *x509.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC).Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert
+}
+
+func TestCertificateBinding(t *testing.T) {
+	cert := newTestCertificate(t, "client")
+	another := newTestCertificate(t, "another")
+
+	sum := sha256.Sum256(cert.Raw)
+
+	if thumbprint := CertificateThumbprint(cert); thumbprint != base64.RawURLEncoding.EncodeToString(sum[:]) {
+		t.Fatalf("unexpected thumbprint: %v", thumbprint)
+	}
+
+	bound := api.AccessToken{Payload: api.AccessTokenPayload{Confirmation: &api.Confirmation{X5TS256: CertificateThumbprint(cert)}}}
+
+	tests := []struct {
+		Name  string
+		Token api.AccessToken
+		Cert  *x509.Certificate
+		Err   error
+	}{
+		{Name: "Bound", Token: bound, Cert: cert},
+		{Name: "Another certificate", Token: bound, Cert: another, Err: ErrCertificateMismatch},
+		{Name: "No certificate", Token: bound, Err: ErrCertificateMismatch},
+		{Name: "Bearer", Token: api.AccessToken{}, Cert: another},
+		{Name: "Bearer without certificate", Token: api.AccessToken{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+
+			if test.Cert != nil {
+				request.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{test.Cert}}
+			}
+
+			if err := CheckCertificateBinding(request, test.Token); !errors.Is(err, test.Err) {
+				t.Fatalf("expected error %v, got %v", test.Err, err)
+			}
+		})
+	}
+}
+
+func TestMiddlewareCertificateBinding(t *testing.T) {
+	signer, err := NewHMACSigner(AlgHS256, []byte("secret"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	verifier := TokenVerifier{
+		Keys: NewKeyring("kid", signer),
+		Time: TimeValidator{Clock: ClockFunc(func() time.Time { return now })},
+	}
+
+	cert := newTestCertificate(t, "client")
+
+	token := newTestToken(now)
+	token.Payload.Confirmation = &api.Confirmation{X5TS256: CertificateThumbprint(cert)}
+
+	if err := SignAccessTokenCompactWith(&token, signer); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := verifier.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, test := range []struct {
+		Name string
+		Cert *x509.Certificate
+		Code int
+	}{
+		{Name: "Same certificate", Cert: cert, Code: http.StatusOK},
+		{Name: "Another certificate", Cert: newTestCertificate(t, "another"), Code: http.StatusUnauthorized},
+		{Name: "No certificate", Code: http.StatusUnauthorized},
+	} {
+		t.Run(test.Name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.Header.Set("Authorization", "Bearer "+token.Raw)
+
+			if test.Cert != nil {
+				request.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{test.Cert}}
+			}
+
+			recorder := httptest.NewRecorder()
+
+			handler.ServeHTTP(recorder, request)
+
+			if recorder.Code != test.Code {
+				t.Fatalf("expected status %v, got %v", test.Code, recorder.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+)
+
+// Supported HMAC algorithms as defined by RFC 7518
+const (
+	AlgHS256 string = "HS256"
+	AlgHS384 string = "HS384"
+	AlgHS512 string = "HS512"
+)
+
+var ErrUnsupportedAlg error = errors.New("unsupported signing algorithm")
+
+// Verifier checks Access token signatures
+type Verifier interface {
+	// Alg returns JWS algorithm name placed to Access token header
+	Alg() string
+	// Verify returns ErrInvalidSignature if signature does not match data
+	Verify(data []byte, signature []byte) error
+}
+
+// Signer calculates Access token signatures
+type Signer interface {
+	Verifier
+	Sign(data []byte) ([]byte, error)
+}
+
+// HMACSigner signs Access tokens with shared secret
+type HMACSigner struct {
+	alg    string
+	hash   func() hash.Hash
+	secret []byte
+}
+
+// NewHMACSigner creates HMACSigner for one of HS256, HS384 or HS512 algorithms
+func NewHMACSigner(alg string, secret []byte) (*HMACSigner, error) {
+	var h func() hash.Hash
+
+	switch alg {
+	case AlgHS256:
+		h = sha256.New
+	case AlgHS384:
+		h = sha512.New384
+	case AlgHS512:
+		h = sha512.New
+	default:
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedAlg, alg)
+	}
+
+	return &HMACSigner{
+		alg:    alg,
+		hash:   h,
+		secret: secret,
+	}, nil
+}
+
+func (s *HMACSigner) Alg() string {
+	return s.alg
+}
+
+func (s *HMACSigner) Sign(data []byte) ([]byte, error) {
+	mac := hmac.New(s.hash, s.secret)
+	mac.Write(data)
+	return mac.Sum(nil), nil
+}
+
+// keyID derives kid from secret, it does not disclose the secret since it is HMAC of fixed label
+func (s *HMACSigner) keyID() string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte("kid"))
+	return "hs-" + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:12])
+}
+
+// Verify compares signatures in constant time
+func (s *HMACSigner) Verify(data []byte, signature []byte) error {
+	expected, err := s.Sign(data)
+
+	if err != nil {
+		return err
+	}
+
+	if !hmac.Equal(expected, signature) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
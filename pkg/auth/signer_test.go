@@ -0,0 +1,105 @@
+package auth
+
+import (
+	api "authservice/pkg/api"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNewHMACSigner(t *testing.T) {
+	secret := []byte("my interesting secret")
+
+	for alg, size := range map[string]int{AlgHS256: 32, AlgHS384: 48, AlgHS512: 64} {
+		signer, err := NewHMACSigner(alg, secret)
+
+		if err != nil {
+			t.Fatalf("failed to create %v signer: %v", alg, err)
+		}
+
+		if signer.Alg() != alg {
+			t.Errorf("expected signer algorithm %v, got %v", alg, signer.Alg())
+		}
+
+		signature, err := signer.Sign([]byte("data"))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(signature) != size {
+			t.Errorf("expected %v signature length %v, got %v", alg, size, len(signature))
+		}
+
+		if err := signer.Verify([]byte("data"), signature); err != nil {
+			t.Errorf("failed to verify %v signature: %v", alg, err)
+		}
+
+		if err := signer.Verify([]byte("another data"), signature); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("%v signature of another data was verified", alg)
+		}
+	}
+
+	if _, err := NewHMACSigner("SHA512", secret); !errors.Is(err, ErrUnsupportedAlg) {
+		t.Fatalf("unsupported algorithm was accepted")
+	}
+}
+
+func TestHMACSignerIsHMAC(t *testing.T) {
+	secret := []byte("my interesting secret")
+
+	signer, err := NewHMACSigner(AlgHS256, secret)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signature, err := signer.Sign([]byte("data"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("data"))
+
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		t.Fatalf("signature is not HMAC-SHA256 of data")
+	}
+}
+
+func TestVerifyAccessTokenWithAlgMismatch(t *testing.T) {
+	secret := []byte("my interesting secret")
+
+	hs256, err := NewHMACSigner(AlgHS256, secret)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hs512, err := NewHMACSigner(AlgHS512, secret)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := api.NewAccessToken(time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC), "session")
+
+	if err := SignAccessTokenCompactWith(&token, hs256); err != nil {
+		t.Fatal(err)
+	}
+
+	if token.Header.Alg != AlgHS256 {
+		t.Fatalf("token header algorithm was not set to signer algorithm: %v", token.Header.Alg)
+	}
+
+	if err := VerifyAccessTokenWith(token, hs256); err != nil {
+		t.Fatalf("failed to verify token: %v", err)
+	}
+
+	if err := VerifyAccessTokenWith(token, hs512); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("token signed with another algorithm was verified")
+	}
+}
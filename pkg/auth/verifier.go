@@ -0,0 +1,93 @@
+package auth
+
+import (
+	api "authservice/pkg/api"
+	"errors"
+	"fmt"
+)
+
+var ErrInvalidClaims error = errors.New("invalid Access token claims")
+var ErrTokenExpired error = errors.New("Access token is expired")
+var ErrTokenNotYetValid error = errors.New("Access token is not valid yet")
+
+// KeySource checks Access token signatures, it is implemented by Keyring
+type KeySource interface {
+	VerifyAccessToken(token api.AccessToken) error
+}
+
+// StaticKeys is KeySource of fixed verify-only keys by kid for services which only check Access tokens
+type StaticKeys map[string]Verifier
+
+func (k StaticKeys) VerifyAccessToken(token api.AccessToken) error {
+	verifier, ok := k[token.Header.Kid]
+
+	if !ok {
+		return fmt.Errorf("%w: %v: %v", ErrInvalidSignature, ErrUnknownKey, token.Header.Kid)
+	}
+
+	return VerifyAccessTokenWith(token, verifier)
+}
+
+// TokenVerifier checks Access token signature, expiration and registered claims
+type TokenVerifier struct {
+	Keys KeySource
+	// Issuer is expected "iss" claim, not checked when empty
+	Issuer string
+	// Audience is expected "aud" claim, not checked when empty
+	Audience string
+	// Time checks expiration with clock skew leeway, zero value uses system clock without leeway
+	Time TimeValidator
+	// DPoP checks proofs of DPoP bound Access tokens in Middleware, bound tokens are rejected when it is nil
+	DPoP *DPoPValidator
+}
+
+// Verify checks signature and claims of the token
+func (v TokenVerifier) Verify(token api.AccessToken) error {
+	if err := v.Keys.VerifyAccessToken(token); err != nil {
+		return err
+	}
+
+	return v.ValidateClaims(token)
+}
+
+// Parse parses compact serialization of access token and verifies it
+func (v TokenVerifier) Parse(s string) (api.AccessToken, error) {
+	token, err := api.ParseCompactAccessToken(s)
+
+	if err != nil {
+		return api.AccessToken{}, err
+	}
+
+	if err = v.Verify(token); err != nil {
+		return api.AccessToken{}, err
+	}
+
+	return token, nil
+}
+
+// ValidateClaims checks expiration, time and registered claims of the token, signature is not checked
+func (v TokenVerifier) ValidateClaims(token api.AccessToken) error {
+	if err := v.Time.Validate(token); err != nil {
+		return err
+	}
+
+	payload := token.Payload
+
+	if payload.Subject == "" {
+		return fmt.Errorf("%w: no subject", ErrInvalidClaims)
+	}
+
+	if payload.ID == "" {
+		return fmt.Errorf("%w: no token id", ErrInvalidClaims)
+	}
+
+	if v.Issuer != "" && payload.Issuer != v.Issuer {
+		return fmt.Errorf("%w: unexpected issuer %v", ErrInvalidClaims, payload.Issuer)
+	}
+
+	if v.Audience != "" && payload.Audience != v.Audience {
+		return fmt.Errorf("%w: unexpected audience %v", ErrInvalidClaims, payload.Audience)
+	}
+
+	return nil
+}
@@ -0,0 +1,137 @@
+package auth
+
+import (
+	api "authservice/pkg/api"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestToken(now time.Time) api.AccessToken {
+	token := api.NewAccessToken(now.Add(time.Hour), "session")
+	token.Payload.Subject = "guid"
+	token.Payload.Issuer = "issuer"
+	token.Payload.Audience = "audience"
+	token.Payload.IssuedAt = now.Unix()
+	token.Payload.NotBefore = now.Unix()
+	token.Payload.ID = "id"
+	return token
+}
+
+func TestTokenVerifier(t *testing.T) {
+	signer, err := NewHMACSigner(AlgHS256, []byte("secret"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	verifier := TokenVerifier{
+		Keys:     NewKeyring("kid", signer),
+		Issuer:   "issuer",
+		Audience: "audience",
+		Time:     TimeValidator{Clock: ClockFunc(func() time.Time { return now })},
+	}
+
+	tests := []struct {
+		Name   string
+		Change func(token *api.AccessToken)
+		Err    error
+	}{
+		{Name: "Ok", Change: func(token *api.AccessToken) {}},
+		{Name: "Expired", Change: func(token *api.AccessToken) { token.Header.Exp = now.Add(-time.Minute) }, Err: ErrTokenExpired},
+		{Name: "Not before", Change: func(token *api.AccessToken) { token.Payload.NotBefore = now.Add(time.Minute).Unix() }, Err: ErrTokenNotYetValid},
+		{Name: "Issued in future", Change: func(token *api.AccessToken) { token.Payload.IssuedAt = now.Add(time.Minute).Unix() }, Err: ErrInvalidClaims},
+		{Name: "No subject", Change: func(token *api.AccessToken) { token.Payload.Subject = "" }, Err: ErrInvalidClaims},
+		{Name: "No id", Change: func(token *api.AccessToken) { token.Payload.ID = "" }, Err: ErrInvalidClaims},
+		{Name: "Wrong issuer", Change: func(token *api.AccessToken) { token.Payload.Issuer = "another" }, Err: ErrInvalidClaims},
+		{Name: "Wrong audience", Change: func(token *api.AccessToken) { token.Payload.Audience = "another" }, Err: ErrInvalidClaims},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			token := newTestToken(now)
+			test.Change(&token)
+
+			if err := SignAccessTokenCompactWith(&token, signer); err != nil {
+				t.Fatal(err)
+			}
+
+			parsed, err := verifier.Parse(token.Raw)
+
+			if test.Err == nil {
+				if err != nil {
+					t.Fatalf("failed to verify token: %v", err)
+				}
+
+				if parsed.Payload != token.Payload {
+					t.Fatalf("parsed token payload is not equal to signed token: %#v %#v", parsed.Payload, token.Payload)
+				}
+			} else if !errors.Is(err, test.Err) {
+				t.Fatalf("expected error %v, got %v", test.Err, err)
+			}
+		})
+	}
+}
+
+func TestTokenVerifierSignature(t *testing.T) {
+	signer, err := NewHMACSigner(AlgHS256, []byte("secret"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	another, err := NewHMACSigner(AlgHS256, []byte("another secret"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	verifier := TokenVerifier{Keys: NewKeyring("kid", signer), Time: TimeValidator{Clock: ClockFunc(func() time.Time { return now })}}
+
+	token := newTestToken(now)
+
+	if err := SignAccessTokenCompactWith(&token, another); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := verifier.Parse(token.Raw); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("token signed with another key was verified: %v", err)
+	}
+}
+
+func TestStaticKeys(t *testing.T) {
+	signer, err := NewHMACSigner(AlgHS256, []byte("secret"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	verifier := TokenVerifier{Keys: StaticKeys{"kid": signer}, Time: TimeValidator{Clock: ClockFunc(func() time.Time { return now })}}
+
+	token := newTestToken(now)
+	token.Header.Kid = "kid"
+
+	if err := SignAccessTokenCompactWith(&token, signer); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := verifier.Parse(token.Raw); err != nil {
+		t.Fatalf("failed to verify token: %v", err)
+	}
+
+	token.Header.Kid = "another"
+
+	if err := SignAccessTokenCompactWith(&token, signer); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := verifier.Parse(token.Raw); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("token with unknown kid was verified: %v", err)
+	}
+}
@@ -0,0 +1,40 @@
+package events
+
+import (
+	"log"
+	"time"
+)
+
+// Type is kind of security event
+type Type string
+
+const (
+	// RefreshTokenReuse is raised when already rotated Refresh token is presented again
+	RefreshTokenReuse Type = "refresh_token_reuse"
+	// SessionEvicted is raised when session is revoked to keep user within limit of active sessions
+	SessionEvicted Type = "session_evicted"
+)
+
+// Event is security relevant event of user session
+type Event struct {
+	Type    Type
+	GUID    string
+	Session string
+	Ip      string
+	Time    time.Time
+	Details string
+}
+
+// Publisher is Interface for raising security events
+type Publisher interface {
+	Publish(event Event)
+}
+
+// LogPublisher writes security events to log
+type LogPublisher struct {
+}
+
+func (p LogPublisher) Publish(event Event) {
+	log.Default().Printf("security event %v: GUID: %v, session: %v, ip: %v, time: %v, details: %v\n",
+		event.Type, event.GUID, event.Session, event.Ip, event.Time.Format(time.RFC3339), event.Details)
+}
@@ -0,0 +1,230 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+type rotatedKey struct {
+	session    string
+	generation int
+}
+
+// MemoryStore is SessionStore kept in process memory, sessions are lost on restart
+// It suits tests and single instance deployments without database
+type MemoryStore struct {
+	mu       sync.Mutex
+	sessions map[string]Session
+	rotated  map[rotatedKey]RotatedToken
+}
+
+// NewMemoryStore returns empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		sessions: map[string]Session{},
+		rotated:  map[rotatedKey]RotatedToken{},
+	}
+}
+
+// Create stores new session
+func (s *MemoryStore) Create(session Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.sessions[session.ID]; ok {
+		return fmt.Errorf("failed to add session: %v, got error: session exists", session.ID)
+	}
+
+	s.sessions[session.ID] = session
+
+	return nil
+}
+
+// CreateLimited stores new session unless user reached limit of active sessions
+func (s *MemoryStore) CreateLimited(session Session, limit SessionLimit, now time.Time) ([]Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.sessions[session.ID]; ok {
+		return nil, fmt.Errorf("failed to add session: %v, got error: session exists", session.ID)
+	}
+
+	var active []Session
+
+	for _, stored := range s.sessions {
+		if stored.GUID == session.GUID && stored.Active(now) {
+			active = append(active, stored)
+		}
+	}
+
+	evicted, err := limit.evictions(active)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to add session: %v, got error: %w", session.ID, err)
+	}
+
+	for i := range evicted {
+		evicted[i].RevokedAt = now
+		s.sessions[evicted[i].ID] = evicted[i]
+	}
+
+	s.sessions[session.ID] = session
+
+	return evicted, nil
+}
+
+// Get loads session by id
+func (s *MemoryStore) Get(id string) (Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+
+	if !ok {
+		return Session{}, fmt.Errorf("failed to get session: %v, got error: %w", id, ErrNotFound)
+	}
+
+	return session, nil
+}
+
+// Rotate replaces Refresh token of current session and keeps hash of replaced token
+func (s *MemoryStore) Rotate(current Session, next Session, rotatedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[current.ID]
+
+	if !ok || session.Generation != current.Generation || session.Revoked() {
+		return fmt.Errorf("failed to rotate session: %v, got error: %w", current.ID, ErrConcurrentRotation)
+	}
+
+	session.TokenHash = next.TokenHash
+	session.TokenHasher = next.TokenHasher
+	session.Ip = next.Ip
+	session.ExpiresAt = next.ExpiresAt
+	session.Generation = current.Generation + 1
+	session.LastRefreshedAt = rotatedAt
+	session.UserAgent = next.UserAgent
+	session.DeviceName = next.DeviceName
+
+	s.sessions[current.ID] = session
+	s.rotated[rotatedKey{current.ID, current.Generation}] = RotatedToken{
+		TokenHash:   current.TokenHash,
+		TokenHasher: current.TokenHasher,
+		RotatedAt:   rotatedAt,
+	}
+
+	return nil
+}
+
+// GetRotated returns hash of Refresh token of session replaced at given generation
+func (s *MemoryStore) GetRotated(id string, generation int) (RotatedToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rotated, ok := s.rotated[rotatedKey{id, generation}]
+
+	if !ok {
+		return RotatedToken{}, fmt.Errorf("failed to get rotated refresh token hash for session: %v, got error: %w", id, ErrNotFound)
+	}
+
+	return rotated, nil
+}
+
+// Revoke marks session revoked
+func (s *MemoryStore) Revoke(id string, revokedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if session, ok := s.sessions[id]; ok && !session.Revoked() {
+		session.RevokedAt = revokedAt
+		s.sessions[id] = session
+	}
+
+	return nil
+}
+
+// RevokeUser revokes all sessions of user except session passed in except
+func (s *MemoryStore) RevokeUser(GUID string, except string, revokedAt time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var revoked int64
+
+	for id, session := range s.sessions {
+		if session.GUID != GUID || id == except || session.Revoked() {
+			continue
+		}
+
+		session.RevokedAt = revokedAt
+		s.sessions[id] = session
+		revoked++
+	}
+
+	return revoked, nil
+}
+
+// List returns active sessions of user
+func (s *MemoryStore) List(GUID string, now time.Time) ([]Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var result []Session
+
+	for _, session := range s.sessions {
+		if session.GUID == GUID && session.Active(now) {
+			result = append(result, session)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].CreatedAt.Equal(result[j].CreatedAt) {
+			return result[i].CreatedAt.Before(result[j].CreatedAt)
+		}
+		return result[i].ID < result[j].ID
+	})
+
+	return result, nil
+}
+
+// Purge deletes expired and revoked sessions with their rotated tokens, oldest first
+func (s *MemoryStore) Purge(expiredBefore time.Time, revokedBefore time.Time, limit int) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var purge []Session
+
+	for _, session := range s.sessions {
+		if session.ExpiresAt.Before(expiredBefore) || (session.Revoked() && session.RevokedAt.Before(revokedBefore)) {
+			purge = append(purge, session)
+		}
+	}
+
+	sort.Slice(purge, func(i, j int) bool {
+		if !purge[i].ExpiresAt.Equal(purge[j].ExpiresAt) {
+			return purge[i].ExpiresAt.Before(purge[j].ExpiresAt)
+		}
+		return purge[i].ID < purge[j].ID
+	})
+
+	if limit > 0 && len(purge) > limit {
+		purge = purge[:limit]
+	}
+
+	for _, session := range purge {
+		delete(s.sessions, session.ID)
+
+		for generation := 0; generation < session.Generation; generation++ {
+			delete(s.rotated, rotatedKey{session.ID, generation})
+		}
+	}
+
+	return int64(len(purge)), nil
+}
+
+// Close does nothing, sessions stay in memory
+func (s *MemoryStore) Close() error {
+	return nil
+}
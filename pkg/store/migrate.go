@@ -0,0 +1,332 @@
+package store
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+// ErrUnknownMigration is returned when database has migration which is not embedded into service, it was applied by newer version
+var ErrUnknownMigration error = errors.New("database has unknown migration")
+
+// Migration is versioned schema change, files are named <version>_<name>.up.sql and <version>_<name>.down.sql
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus tells whether migration is applied to database
+type MigrationStatus struct {
+	Migration
+	Applied bool
+	// AppliedAt is zero for migrations which are not applied
+	AppliedAt time.Time
+}
+
+// Migrator manages schema of store, stores without schema do not implement it
+type Migrator interface {
+	// MigrateUp applies all pending migrations, returns number of applied migrations
+	MigrateUp() (int, error)
+	// MigrateDown reverts at most steps latest applied migrations, returns number of reverted migrations
+	MigrateDown(steps int) (int, error)
+	// MigrationStatus lists embedded migrations in version order
+	MigrationStatus() ([]MigrationStatus, error)
+}
+
+// loadMigrations reads embedded migrations of dialect in version order
+func loadMigrations(dialect dialect) ([]Migration, error) {
+	dir := path.Join("migrations", dialect.name)
+
+	entries, err := fs.ReadDir(migrationFiles, dir)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations of %v: %v", dialect.name, err)
+	}
+
+	migrations := map[int]*Migration{}
+
+	for _, entry := range entries {
+		name, direction, ok := strings.Cut(strings.TrimSuffix(entry.Name(), ".sql"), ".")
+
+		if !ok || !strings.HasSuffix(entry.Name(), ".sql") || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("unexpected migration file name: %v", entry.Name())
+		}
+
+		versionString, name, _ := strings.Cut(name, "_")
+
+		version, err := strconv.Atoi(versionString)
+
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("unexpected migration version: %v", entry.Name())
+		}
+
+		content, err := fs.ReadFile(migrationFiles, path.Join(dir, entry.Name()))
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %v: %v", entry.Name(), err)
+		}
+
+		migration, ok := migrations[version]
+
+		if !ok {
+			migration = &Migration{Version: version, Name: name}
+			migrations[version] = migration
+		}
+
+		if migration.Name != name {
+			return nil, fmt.Errorf("migrations %v and %v have same version", migration.Name, name)
+		}
+
+		if direction == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	result := make([]Migration, 0, len(migrations))
+
+	for _, migration := range migrations {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %v must have up and down files", migration.Name)
+		}
+
+		result = append(result, *migration)
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Version < result[j].Version })
+
+	return result, nil
+}
+
+// lockMigrations serializes migrations of several service instances starting at once
+const lockMigrations string = "SELECT pg_advisory_xact_lock(hashtext('schema_migrations'))"
+
+func (s *SQLStore) createMigrationsTable() error {
+	_, err := s.db.Exec("CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY, name TEXT NOT NULL, applied_at TIMESTAMP NOT NULL)")
+
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %v", err)
+	}
+
+	return nil
+}
+
+// appliedMigrations returns moments migrations were applied at by version
+func (s *SQLStore) appliedMigrations() (map[int]time.Time, error) {
+	if err := s.createMigrationsTable(); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query("SELECT version, applied_at FROM schema_migrations")
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %v", err)
+	}
+
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to read schema_migrations: %v", err)
+		}
+
+		applied[version] = appliedAt
+	}
+
+	return applied, rows.Err()
+}
+
+// migrate applies or reverts single migration in transaction, returns false when other instance already did it
+func (s *SQLStore) migrate(migration Migration, up bool) (bool, error) {
+	tx, err := s.db.Begin()
+
+	if err != nil {
+		return false, fmt.Errorf("failed to begin migration %v: %v", migration.Version, err)
+	}
+
+	// Rollback is no-op after successful commit
+	defer tx.Rollback()
+
+	if s.dialect == postgres {
+		if _, err = tx.Exec(lockMigrations); err != nil {
+			return false, fmt.Errorf("failed to lock migrations: %v", err)
+		}
+	}
+
+	var applied int
+
+	if err = tx.QueryRow("SELECT COUNT(*) FROM schema_migrations WHERE version = $1", migration.Version).Scan(&applied); err != nil {
+		return false, fmt.Errorf("failed to check migration %v: %v", migration.Version, err)
+	}
+
+	if (applied == 1) == up {
+		return false, nil
+	}
+
+	script := migration.Down
+
+	if up {
+		script = migration.Up
+	}
+
+	if _, err = tx.Exec(script); err != nil {
+		return false, fmt.Errorf("failed to run migration %v_%v: %v", migration.Version, migration.Name, err)
+	}
+
+	if up {
+		_, err = tx.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)", migration.Version, migration.Name, s.time(time.Now()))
+	} else {
+		_, err = tx.Exec("DELETE FROM schema_migrations WHERE version = $1", migration.Version)
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("failed to record migration %v: %v", migration.Version, err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit migration %v: %v", migration.Version, err)
+	}
+
+	return true, nil
+}
+
+// MigrateUp applies all pending migrations in version order
+func (s *SQLStore) MigrateUp() (int, error) {
+	migrations, err := loadMigrations(s.dialect)
+
+	if err != nil {
+		return 0, err
+	}
+
+	applied, err := s.appliedMigrations()
+
+	if err != nil {
+		return 0, err
+	}
+
+	if err = checkUnknownMigrations(migrations, applied); err != nil {
+		return 0, err
+	}
+
+	count := 0
+
+	for _, migration := range migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+
+		done, err := s.migrate(migration, true)
+
+		if err != nil {
+			return count, err
+		}
+
+		if done {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// MigrateDown reverts at most steps latest applied migrations
+func (s *SQLStore) MigrateDown(steps int) (int, error) {
+	migrations, err := loadMigrations(s.dialect)
+
+	if err != nil {
+		return 0, err
+	}
+
+	applied, err := s.appliedMigrations()
+
+	if err != nil {
+		return 0, err
+	}
+
+	if err = checkUnknownMigrations(migrations, applied); err != nil {
+		return 0, err
+	}
+
+	count := 0
+
+	for i := len(migrations) - 1; i >= 0 && count < steps; i-- {
+		if _, ok := applied[migrations[i].Version]; !ok {
+			continue
+		}
+
+		done, err := s.migrate(migrations[i], false)
+
+		if err != nil {
+			return count, err
+		}
+
+		if done {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// MigrationStatus lists embedded migrations with state of database
+func (s *SQLStore) MigrationStatus() ([]MigrationStatus, error) {
+	migrations, err := loadMigrations(s.dialect)
+
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := s.appliedMigrations()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err = checkUnknownMigrations(migrations, applied); err != nil {
+		return nil, err
+	}
+
+	result := make([]MigrationStatus, 0, len(migrations))
+
+	for _, migration := range migrations {
+		appliedAt, ok := applied[migration.Version]
+
+		result = append(result, MigrationStatus{Migration: migration, Applied: ok, AppliedAt: appliedAt})
+	}
+
+	return result, nil
+}
+
+// checkUnknownMigrations refuses to work with schema changed by newer version of service
+func checkUnknownMigrations(migrations []Migration, applied map[int]time.Time) error {
+	known := map[int]bool{}
+
+	for _, migration := range migrations {
+		known[migration.Version] = true
+	}
+
+	for version := range applied {
+		if !known[version] {
+			return fmt.Errorf("%w: %v", ErrUnknownMigration, version)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,177 @@
+package store
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	var versions []int
+
+	for _, dialect := range []dialect{postgres, sqlite} {
+		migrations, err := loadMigrations(dialect)
+
+		if err != nil {
+			t.Fatalf("failed to load migrations of %v: %v", dialect.name, err)
+		}
+
+		if len(migrations) == 0 {
+			t.Fatalf("there is no migrations of %v", dialect.name)
+		}
+
+		for i, migration := range migrations {
+			if migration.Up == "" || migration.Down == "" {
+				t.Fatalf("migration %v of %v has no up or down script", migration.Version, dialect.name)
+			}
+
+			if i > 0 && migrations[i-1].Version >= migration.Version {
+				t.Fatalf("migrations of %v are not ordered by version", dialect.name)
+			}
+		}
+
+		if versions == nil {
+			for _, migration := range migrations {
+				versions = append(versions, migration.Version)
+			}
+			continue
+		}
+
+		// Every dialect has to reach same schema version
+		if len(versions) != len(migrations) || versions[len(versions)-1] != migrations[len(migrations)-1].Version {
+			t.Fatalf("migrations of %v differ from other dialects", dialect.name)
+		}
+	}
+}
+
+func TestMigrateSQLite(t *testing.T) {
+	store, err := OpenSQLite(":memory:")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer store.Close()
+
+	migrations, err := loadMigrations(sqlite)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := store.MigrationStatus()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, migration := range status {
+		if migration.Applied || !migration.AppliedAt.IsZero() {
+			t.Fatalf("migration %v is applied to new database", migration.Version)
+		}
+	}
+
+	if _, err := store.Get("session"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Fatalf("sessions table exists before migration: %v", err)
+	}
+
+	applied, err := store.MigrateUp()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if applied != len(migrations) {
+		t.Fatalf("expected %v applied migrations, got %v", len(migrations), applied)
+	}
+
+	if applied, err = store.MigrateUp(); err != nil || applied != 0 {
+		t.Fatalf("repeated migration applied %v migrations: %v", applied, err)
+	}
+
+	if _, err := store.Get("session"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("sessions table was not created: %v", err)
+	}
+
+	if status, err = store.MigrationStatus(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, migration := range status {
+		if !migration.Applied || migration.AppliedAt.IsZero() {
+			t.Fatalf("migration %v is not applied", migration.Version)
+		}
+	}
+
+	reverted, err := store.MigrateDown(1)
+
+	if err != nil || reverted != 1 {
+		t.Fatalf("expected one reverted migration, got %v: %v", reverted, err)
+	}
+
+	if status, err = store.MigrationStatus(); err != nil {
+		t.Fatal(err)
+	}
+
+	if last := status[len(status)-1]; last.Applied {
+		t.Fatalf("latest migration %v was not reverted", last.Version)
+	}
+
+	if reverted, err = store.MigrateDown(len(migrations) + 1); err != nil || reverted != len(migrations)-1 {
+		t.Fatalf("expected %v reverted migrations, got %v: %v", len(migrations)-1, reverted, err)
+	}
+
+	if _, err := store.Get("session"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Fatalf("sessions table exists after all migrations were reverted: %v", err)
+	}
+
+	if applied, err = store.MigrateUp(); err != nil || applied != len(migrations) {
+		t.Fatalf("expected %v applied migrations after revert, got %v: %v", len(migrations), applied, err)
+	}
+}
+
+func TestMigrateBackfillCreatedAt(t *testing.T) {
+	store := openTestSQLite(t)
+
+	// Session created before creation time was stored
+	if err := store.Create(Session{ID: "legacy", GUID: "guid", TokenHash: "hash", TokenHasher: "bcrypt", ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Backfill is reapplied after its revert, it has to stay latest migration or be reverted with ones after it
+	if reverted, err := store.MigrateDown(1); err != nil || reverted != 1 {
+		t.Fatalf("expected one reverted migration, got %v: %v", reverted, err)
+	}
+
+	before := time.Now().Add(-time.Second)
+
+	if _, err := store.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := store.Get("legacy")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if session.CreatedAt.Before(before) || session.CreatedAt.After(time.Now().Add(time.Second)) {
+		t.Fatalf("creation time was not backfilled with migration time: %v", session.CreatedAt)
+	}
+}
+
+func TestMigrateUnknownVersion(t *testing.T) {
+	store := openTestSQLite(t)
+
+	if _, err := store.db.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)", 9999, "future", time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.MigrateUp(); !errors.Is(err, ErrUnknownMigration) {
+		t.Fatalf("expected ErrUnknownMigration, got: %v", err)
+	}
+
+	if _, err := store.MigrateDown(1); !errors.Is(err, ErrUnknownMigration) {
+		t.Fatalf("expected ErrUnknownMigration, got: %v", err)
+	}
+}
@@ -0,0 +1,26 @@
+-- Tables created by hand before migrations existed are kept, missing columns are added
+CREATE TABLE IF NOT EXISTS sessions (
+	session_id TEXT PRIMARY KEY,
+	GUID TEXT NOT NULL,
+	token_hash TEXT NOT NULL,
+	expires_at TIMESTAMP
+);
+
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS token_hasher TEXT NOT NULL DEFAULT 'bcrypt';
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS revoked_at TIMESTAMP;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS generation INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS ip TEXT;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS created_at TIMESTAMP;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS client_id TEXT;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS dpop_jkt TEXT;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS cert_thumbprint TEXT;
+
+CREATE TABLE IF NOT EXISTS rotated_refresh_tokens (
+	session_id TEXT NOT NULL,
+	generation INTEGER NOT NULL,
+	token_hash TEXT NOT NULL,
+	rotated_at TIMESTAMP,
+	PRIMARY KEY (session_id, generation)
+);
+
+ALTER TABLE rotated_refresh_tokens ADD COLUMN IF NOT EXISTS token_hasher TEXT NOT NULL DEFAULT 'bcrypt';
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS sessions_expires_at_idx;
+DROP INDEX IF EXISTS sessions_guid_idx;
@@ -0,0 +1,3 @@
+ALTER TABLE sessions DROP COLUMN IF EXISTS device_name;
+ALTER TABLE sessions DROP COLUMN IF EXISTS user_agent;
+ALTER TABLE sessions DROP COLUMN IF EXISTS last_refreshed_at;
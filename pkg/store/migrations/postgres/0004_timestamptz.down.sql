@@ -0,0 +1,5 @@
+ALTER TABLE sessions ALTER COLUMN expires_at TYPE TIMESTAMP USING expires_at AT TIME ZONE 'UTC';
+ALTER TABLE sessions ALTER COLUMN revoked_at TYPE TIMESTAMP USING revoked_at AT TIME ZONE 'UTC';
+ALTER TABLE sessions ALTER COLUMN created_at TYPE TIMESTAMP USING created_at AT TIME ZONE 'UTC';
+ALTER TABLE sessions ALTER COLUMN last_refreshed_at TYPE TIMESTAMP USING last_refreshed_at AT TIME ZONE 'UTC';
+ALTER TABLE rotated_refresh_tokens ALTER COLUMN rotated_at TYPE TIMESTAMP USING rotated_at AT TIME ZONE 'UTC';
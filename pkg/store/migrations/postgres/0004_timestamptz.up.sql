@@ -0,0 +1,6 @@
+-- Columns keep UTC wall time, it is converted to absolute time
+ALTER TABLE sessions ALTER COLUMN expires_at TYPE TIMESTAMPTZ USING expires_at AT TIME ZONE 'UTC';
+ALTER TABLE sessions ALTER COLUMN revoked_at TYPE TIMESTAMPTZ USING revoked_at AT TIME ZONE 'UTC';
+ALTER TABLE sessions ALTER COLUMN created_at TYPE TIMESTAMPTZ USING created_at AT TIME ZONE 'UTC';
+ALTER TABLE sessions ALTER COLUMN last_refreshed_at TYPE TIMESTAMPTZ USING last_refreshed_at AT TIME ZONE 'UTC';
+ALTER TABLE rotated_refresh_tokens ALTER COLUMN rotated_at TYPE TIMESTAMPTZ USING rotated_at AT TIME ZONE 'UTC';
@@ -0,0 +1,2 @@
+-- Sessions created before creation time was stored get migration time, so absolute lifetime caps them too
+UPDATE sessions SET created_at = now() WHERE created_at IS NULL;
@@ -0,0 +1,23 @@
+CREATE TABLE sessions (
+	session_id TEXT PRIMARY KEY,
+	GUID TEXT NOT NULL,
+	token_hash TEXT NOT NULL,
+	token_hasher TEXT NOT NULL DEFAULT 'bcrypt',
+	expires_at TIMESTAMP,
+	revoked_at TIMESTAMP,
+	generation INTEGER NOT NULL DEFAULT 0,
+	ip TEXT,
+	created_at TIMESTAMP,
+	client_id TEXT,
+	dpop_jkt TEXT,
+	cert_thumbprint TEXT
+);
+
+CREATE TABLE rotated_refresh_tokens (
+	session_id TEXT NOT NULL,
+	generation INTEGER NOT NULL,
+	token_hash TEXT NOT NULL,
+	token_hasher TEXT NOT NULL DEFAULT 'bcrypt',
+	rotated_at TIMESTAMP,
+	PRIMARY KEY (session_id, generation)
+);
@@ -0,0 +1,3 @@
+-- Sessions of user are revoked and listed by GUID, expired sessions are purged by expiry
+CREATE INDEX IF NOT EXISTS sessions_guid_idx ON sessions (GUID);
+CREATE INDEX IF NOT EXISTS sessions_expires_at_idx ON sessions (expires_at);
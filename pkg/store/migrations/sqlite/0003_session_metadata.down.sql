@@ -0,0 +1,3 @@
+ALTER TABLE sessions DROP COLUMN device_name;
+ALTER TABLE sessions DROP COLUMN user_agent;
+ALTER TABLE sessions DROP COLUMN last_refreshed_at;
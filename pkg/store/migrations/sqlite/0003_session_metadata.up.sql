@@ -0,0 +1,3 @@
+ALTER TABLE sessions ADD COLUMN last_refreshed_at TIMESTAMP;
+ALTER TABLE sessions ADD COLUMN user_agent TEXT;
+ALTER TABLE sessions ADD COLUMN device_name TEXT;
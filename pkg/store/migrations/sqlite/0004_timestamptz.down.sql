@@ -0,0 +1,2 @@
+-- SQLite has no time zone aware type, times are stored in UTC, version is kept in line with Postgres
+SELECT 1;
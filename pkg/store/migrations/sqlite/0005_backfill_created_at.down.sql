@@ -0,0 +1,2 @@
+-- Backfilled creation times can not be told from real ones, they are kept
+SELECT 1;
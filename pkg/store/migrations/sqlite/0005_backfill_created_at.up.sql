@@ -0,0 +1,2 @@
+-- Sessions created before creation time was stored get migration time, so absolute lifetime caps them too
+UPDATE sessions SET created_at = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE created_at IS NULL;
@@ -0,0 +1,384 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// dialect describes differences of SQL databases supported by SQLStore
+type dialect struct {
+	name string
+}
+
+var (
+	postgres dialect = dialect{name: "postgres"}
+	sqlite   dialect = dialect{name: "sqlite"}
+)
+
+// SQLStore is SessionStore kept in sessions and rotated_refresh_tokens tables
+type SQLStore struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+// NewPostgresStore returns SessionStore kept in Postgres database
+func NewPostgresStore(db *sql.DB) *SQLStore {
+	return &SQLStore{db: db, dialect: postgres}
+}
+
+// NewSQLiteStore returns SessionStore kept in SQLite database
+func NewSQLiteStore(db *sql.DB) *SQLStore {
+	return &SQLStore{db: db, dialect: sqlite}
+}
+
+// DB returns database of store
+func (s *SQLStore) DB() *sql.DB {
+	return s.db
+}
+
+// time converts t to value stored in database
+// Times are kept in UTC: SQLite compares timestamps as text and Postgres columns created without time zone
+// drop offset, so local times would be read back shifted
+func (s *SQLStore) time(t time.Time) time.Time {
+	return t.UTC()
+}
+
+// nullTime converts t to nullable value stored in database, zero time is stored as NULL
+func (s *SQLStore) nullTime(t time.Time) sql.NullTime {
+	if t.IsZero() {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: s.time(t), Valid: true}
+}
+
+const sessionColumns string = "session_id, GUID, token_hash, token_hasher, expires_at, revoked_at, generation, COALESCE(ip, ''), created_at, COALESCE(client_id, ''), COALESCE(dpop_jkt, ''), COALESCE(cert_thumbprint, ''), last_refreshed_at, COALESCE(user_agent, ''), COALESCE(device_name, '')"
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSession(row scanner) (Session, error) {
+	var result Session
+	var revokedAt, createdAt, lastRefreshedAt sql.NullTime
+
+	err := row.Scan(&result.ID, &result.GUID, &result.TokenHash, &result.TokenHasher, &result.ExpiresAt, &revokedAt, &result.Generation,
+		&result.Ip, &createdAt, &result.ClientID, &result.DPoPJKT, &result.CertThumbprint,
+		&lastRefreshedAt, &result.UserAgent, &result.DeviceName)
+
+	if err != nil {
+		return Session{}, err
+	}
+
+	result.RevokedAt = revokedAt.Time
+	result.CreatedAt = createdAt.Time
+	result.LastRefreshedAt = lastRefreshedAt.Time
+
+	return result, nil
+}
+
+// execer is *sql.DB or *sql.Tx
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// insert adds row of session to sessions table
+func (s *SQLStore) insert(db execer, session Session) error {
+	_, err := db.Exec("INSERT INTO sessions (session_id, GUID, token_hash, token_hasher, ip, expires_at, created_at, client_id, dpop_jkt, cert_thumbprint, last_refreshed_at, user_agent, device_name) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)",
+		session.ID, session.GUID, session.TokenHash, session.TokenHasher, session.Ip, s.time(session.ExpiresAt), s.nullTime(session.CreatedAt),
+		session.ClientID, session.DPoPJKT, session.CertThumbprint, s.nullTime(session.LastRefreshedAt), session.UserAgent, session.DeviceName)
+
+	if err != nil {
+		return fmt.Errorf("failed to add session: %v, got error: %v", session.ID, err)
+	}
+
+	return nil
+}
+
+// Create stores new session
+func (s *SQLStore) Create(session Session) error {
+	return s.insert(s.db, session)
+}
+
+// CreateLimited stores new session unless user reached limit of active sessions, in one transaction
+func (s *SQLStore) CreateLimited(session Session, limit SessionLimit, now time.Time) ([]Session, error) {
+	if limit.Max <= 0 {
+		return nil, s.Create(session)
+	}
+
+	tx, err := s.db.Begin()
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin adding session: %v, got error: %v", session.ID, err)
+	}
+
+	// Rollback is no-op after successful commit
+	defer tx.Rollback()
+
+	// Concurrent logins of user are serialized, SQLite allows only one writing transaction anyway
+	if s.dialect == postgres {
+		if _, err = tx.Exec("SELECT pg_advisory_xact_lock(hashtext($1))", "sessions:"+session.GUID); err != nil {
+			return nil, fmt.Errorf("failed to lock sessions of user: %v, got error: %v", session.GUID, err)
+		}
+	}
+
+	rows, err := tx.Query("SELECT "+sessionColumns+" FROM sessions WHERE GUID = $1 AND revoked_at IS NULL AND expires_at > $2", session.GUID, s.time(now))
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to count sessions of user: %v, got error: %v", session.GUID, err)
+	}
+
+	var active []Session
+
+	for rows.Next() {
+		stored, err := scanSession(rows)
+
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to read session of user: %v, got error: %v", session.GUID, err)
+		}
+
+		active = append(active, stored)
+	}
+
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to count sessions of user: %v, got error: %v", session.GUID, err)
+	}
+
+	evicted, err := limit.evictions(active)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to add session: %v, got error: %w", session.ID, err)
+	}
+
+	for i := range evicted {
+		if _, err = tx.Exec("UPDATE sessions SET revoked_at = $1 WHERE session_id = $2", s.time(now), evicted[i].ID); err != nil {
+			return nil, fmt.Errorf("failed to evict session: %v, got error: %v", evicted[i].ID, err)
+		}
+
+		evicted[i].RevokedAt = now
+	}
+
+	if err = s.insert(tx, session); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit adding session: %v, got error: %v", session.ID, err)
+	}
+
+	return evicted, nil
+}
+
+// Get loads session by id
+func (s *SQLStore) Get(id string) (Session, error) {
+	result, err := scanSession(s.db.QueryRow("SELECT "+sessionColumns+" FROM sessions WHERE session_id = $1", id))
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return Session{}, fmt.Errorf("failed to get session: %v, got error: %w", id, ErrNotFound)
+	}
+
+	if err != nil {
+		return Session{}, fmt.Errorf("failed to get session: %v, got error: %v", id, err)
+	}
+
+	return result, nil
+}
+
+// Rotate replaces Refresh token of current session and keeps hash of replaced token in one transaction
+func (s *SQLStore) Rotate(current Session, next Session, rotatedAt time.Time) error {
+	tx, err := s.db.Begin()
+
+	if err != nil {
+		return fmt.Errorf("failed to begin rotation of session: %v, got error: %v", current.ID, err)
+	}
+
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE sessions SET token_hash = $1, token_hasher = $2, ip = $3, expires_at = $4, generation = $5, last_refreshed_at = $6, user_agent = $7, device_name = $8 WHERE session_id = $9 AND generation = $10 AND revoked_at IS NULL",
+		next.TokenHash, next.TokenHasher, next.Ip, s.time(next.ExpiresAt), current.Generation+1, s.time(rotatedAt), next.UserAgent, next.DeviceName, current.ID, current.Generation)
+
+	if err != nil {
+		return fmt.Errorf("failed to rotate session: %v, got error: %v", current.ID, err)
+	}
+
+	rotated, err := result.RowsAffected()
+
+	if err != nil {
+		return fmt.Errorf("failed to count rotated sessions: %v, got error: %v", current.ID, err)
+	}
+
+	if rotated == 0 {
+		return fmt.Errorf("failed to rotate session: %v, got error: %w", current.ID, ErrConcurrentRotation)
+	}
+
+	_, err = tx.Exec("INSERT INTO rotated_refresh_tokens (session_id, generation, token_hash, token_hasher, rotated_at) VALUES ($1, $2, $3, $4, $5)",
+		current.ID, current.Generation, current.TokenHash, current.TokenHasher, s.time(rotatedAt))
+
+	if err != nil {
+		return fmt.Errorf("failed to store rotated refresh token of session: %v, got error: %v", current.ID, err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit rotation of session: %v, got error: %v", current.ID, err)
+	}
+
+	return nil
+}
+
+// GetRotated returns hash of Refresh token of session replaced at given generation
+func (s *SQLStore) GetRotated(id string, generation int) (RotatedToken, error) {
+	row := s.db.QueryRow("SELECT token_hash, token_hasher, rotated_at FROM rotated_refresh_tokens WHERE session_id = $1 AND generation = $2", id, generation)
+
+	var result RotatedToken
+
+	err := row.Scan(&result.TokenHash, &result.TokenHasher, &result.RotatedAt)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return RotatedToken{}, fmt.Errorf("failed to get rotated refresh token hash for session: %v, got error: %w", id, ErrNotFound)
+	}
+
+	if err != nil {
+		return RotatedToken{}, fmt.Errorf("failed to get rotated refresh token hash for session: %v, got error: %v", id, err)
+	}
+
+	return result, nil
+}
+
+// Revoke marks session revoked
+func (s *SQLStore) Revoke(id string, revokedAt time.Time) error {
+	_, err := s.db.Exec("UPDATE sessions SET revoked_at = $1 WHERE session_id = $2 AND revoked_at IS NULL", s.time(revokedAt), id)
+
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %v, got error: %v", id, err)
+	}
+
+	return nil
+}
+
+// RevokeUser revokes all sessions of user except session passed in except
+func (s *SQLStore) RevokeUser(GUID string, except string, revokedAt time.Time) (int64, error) {
+	result, err := s.db.Exec("UPDATE sessions SET revoked_at = $1 WHERE GUID = $2 AND session_id != $3 AND revoked_at IS NULL", s.time(revokedAt), GUID, except)
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke sessions of user: %v, got error: %v", GUID, err)
+	}
+
+	revoked, err := result.RowsAffected()
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to count revoked sessions of user: %v, got error: %v", GUID, err)
+	}
+
+	return revoked, nil
+}
+
+// List returns active sessions of user
+func (s *SQLStore) List(GUID string, now time.Time) ([]Session, error) {
+	rows, err := s.db.Query("SELECT "+sessionColumns+" FROM sessions WHERE GUID = $1 AND revoked_at IS NULL AND expires_at > $2 ORDER BY created_at, session_id", GUID, s.time(now))
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions of user: %v, got error: %v", GUID, err)
+	}
+
+	defer rows.Close()
+
+	var result []Session
+
+	for rows.Next() {
+		session, err := scanSession(rows)
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to read session of user: %v, got error: %v", GUID, err)
+		}
+
+		result = append(result, session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list sessions of user: %v, got error: %v", GUID, err)
+	}
+
+	return result, nil
+}
+
+// Purge deletes expired and revoked sessions with their rotated tokens, oldest first
+func (s *SQLStore) Purge(expiredBefore time.Time, revokedBefore time.Time, limit int) (int64, error) {
+	tx, err := s.db.Begin()
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin purge of sessions: %v", err)
+	}
+
+	defer tx.Rollback()
+
+	query := "SELECT session_id FROM sessions WHERE expires_at < $1 OR revoked_at < $2 ORDER BY expires_at, session_id"
+
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", limit)
+	}
+
+	rows, err := tx.Query(query, s.time(expiredBefore), s.time(revokedBefore))
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to select sessions to purge: %v", err)
+	}
+
+	var ids []any
+	var placeholders []string
+
+	for rows.Next() {
+		var id string
+
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to read session to purge: %v", err)
+		}
+
+		ids = append(ids, id)
+		placeholders = append(placeholders, fmt.Sprintf("$%d", len(ids)))
+	}
+
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to select sessions to purge: %v", err)
+	}
+
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	in := strings.Join(placeholders, ", ")
+
+	if _, err = tx.Exec("DELETE FROM rotated_refresh_tokens WHERE session_id IN ("+in+")", ids...); err != nil {
+		return 0, fmt.Errorf("failed to purge rotated refresh tokens: %v", err)
+	}
+
+	result, err := tx.Exec("DELETE FROM sessions WHERE session_id IN ("+in+")", ids...)
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge sessions: %v", err)
+	}
+
+	purged, err := result.RowsAffected()
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to count purged sessions: %v", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit purge of sessions: %v", err)
+	}
+
+	return purged, nil
+}
+
+// Close closes database of store
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
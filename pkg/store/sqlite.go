@@ -0,0 +1,22 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// OpenSQLite opens SQLite database at dsn, tables are created with MigrateUp
+func OpenSQLite(dsn string) (*SQLStore, error) {
+	db, err := sql.Open("sqlite3", dsn)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SQLite database: %v", err)
+	}
+
+	// SQLite allows one writer, single connection also keeps in-memory database shared
+	db.SetMaxOpenConns(1)
+
+	return NewSQLiteStore(db), nil
+}
@@ -0,0 +1,143 @@
+// Package store persists sessions and their Refresh token hashes
+package store
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// ErrNotFound is returned when there is no requested session or rotated token
+var ErrNotFound error = errors.New("not found")
+
+// ErrConcurrentRotation is returned when session was rotated or revoked after it was loaded
+var ErrConcurrentRotation error = errors.New("session was changed concurrently")
+
+// ErrSessionLimit is returned when user reached limit of active sessions and limit policy rejects new ones
+var ErrSessionLimit error = errors.New("session limit reached")
+
+// Session is authentication of user, it keeps hash of current Refresh token
+type Session struct {
+	ID        string
+	GUID      string
+	TokenHash string
+	// TokenHasher is ID of hasher which computed TokenHash
+	TokenHasher string
+	ExpiresAt   time.Time
+	// RevokedAt is moment session was revoked, zero for sessions which are not revoked
+	RevokedAt time.Time
+	// Generation is number of Refresh token rotations in session
+	Generation int
+	// Ip is address of client which received last token pair of session
+	Ip string
+	// CreatedAt is moment of authentication, zero for sessions created before it was stored
+	CreatedAt time.Time
+	// ClientID selects session policy of session
+	ClientID string
+	// DPoPJKT is thumbprint of DPoP proof key session is bound to, empty for bearer sessions
+	DPoPJKT string
+	// CertThumbprint is SHA-256 thumbprint of client certificate session is bound to, empty without mutual TLS
+	CertThumbprint string
+	// LastRefreshedAt is moment of last Refresh token rotation, zero for sessions which were never refreshed
+	LastRefreshedAt time.Time
+	// UserAgent is User-Agent of client which received last token pair of session
+	UserAgent string
+	// DeviceName is optional name of device given by client
+	DeviceName string
+}
+
+// Revoked reports whether session was revoked
+func (s Session) Revoked() bool {
+	return !s.RevokedAt.IsZero()
+}
+
+// Active reports whether session is neither revoked nor expired
+// Stored expiry is authoritative, expiry inside Refresh token is never trusted
+func (s Session) Active(now time.Time) bool {
+	return !s.Revoked() && now.Before(s.ExpiresAt)
+}
+
+// lastActivity returns moment session was last refreshed or created
+func (s Session) lastActivity() time.Time {
+	if !s.LastRefreshedAt.IsZero() {
+		return s.LastRefreshedAt
+	}
+	return s.CreatedAt
+}
+
+// LimitPolicy selects what happens when user with maximum number of active sessions authenticates again
+type LimitPolicy string
+
+const (
+	// LimitReject refuses new session
+	LimitReject LimitPolicy = "reject"
+	// LimitEvict revokes least recently refreshed sessions to make room for new one
+	LimitEvict LimitPolicy = "evict"
+)
+
+// SessionLimit is maximum number of active sessions of user
+type SessionLimit struct {
+	// Max is number of active sessions user can hold, zero or below means unlimited
+	Max    int
+	Policy LimitPolicy
+}
+
+// evictions returns sessions of active to revoke before new session is added,
+// ErrSessionLimit when limit is reached and policy rejects new session
+func (l SessionLimit) evictions(active []Session) ([]Session, error) {
+	if l.Max <= 0 || len(active) < l.Max {
+		return nil, nil
+	}
+
+	if l.Policy != LimitEvict {
+		return nil, ErrSessionLimit
+	}
+
+	// Sessions which were never refreshed count from creation, sessions without both times are evicted first
+	sorted := append([]Session(nil), active...)
+
+	sort.Slice(sorted, func(i, j int) bool {
+		if !sorted[i].lastActivity().Equal(sorted[j].lastActivity()) {
+			return sorted[i].lastActivity().Before(sorted[j].lastActivity())
+		}
+		return sorted[i].ID < sorted[j].ID
+	})
+
+	return sorted[:len(active)-l.Max+1], nil
+}
+
+// RotatedToken is hash of Refresh token replaced by rotation, it is kept to detect reuse
+type RotatedToken struct {
+	TokenHash   string
+	TokenHasher string
+	RotatedAt   time.Time
+}
+
+// SessionStore keeps sessions, implementations are safe for concurrent use
+type SessionStore interface {
+	// Create stores new session
+	Create(session Session) error
+	// CreateLimited stores new session unless user holds limit.Max sessions active at now, checking and adding it atomically.
+	// Depending on limit policy it returns ErrSessionLimit or revokes least recently refreshed sessions at now and returns them
+	CreateLimited(session Session, limit SessionLimit, now time.Time) (evicted []Session, err error)
+	// Get loads session by id, returns ErrNotFound for unknown session
+	Get(id string) (Session, error)
+	// Rotate replaces Refresh token hash, hasher, expiry and client metadata of current session with ones from next,
+	// sets last refresh to rotatedAt and keeps hash of replaced token. Update succeeds only while session has generation it was loaded with
+	// and is not revoked, so of concurrent rotations only one succeeds, others get ErrConcurrentRotation
+	Rotate(current Session, next Session, rotatedAt time.Time) error
+	// GetRotated returns hash of Refresh token of session replaced at given generation, ErrNotFound when there is none
+	GetRotated(id string, generation int) (RotatedToken, error)
+	// Revoke marks session revoked, its Refresh token can not be used anymore
+	Revoke(id string, revokedAt time.Time) error
+	// RevokeUser revokes all sessions of user except session passed in except, empty except revokes every session
+	// Returns number of revoked sessions
+	RevokeUser(GUID string, except string, revokedAt time.Time) (int64, error)
+	// List returns sessions of user which are active at now, oldest first
+	List(GUID string, now time.Time) ([]Session, error)
+	// Purge deletes at most limit sessions which expired before expiredBefore or were revoked before revokedBefore
+	// with their rotated tokens, limit below one deletes all of them. Returns number of deleted sessions
+	Purge(expiredBefore time.Time, revokedBefore time.Time, limit int) (int64, error)
+	// Close releases resources of store
+	Close() error
+}
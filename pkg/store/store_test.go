@@ -0,0 +1,538 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// testSessionStore is conformance suite every SessionStore implementation has to pass
+func testSessionStore(t *testing.T, open func(t *testing.T) SessionStore) {
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	newSession := func(id string, GUID string) Session {
+		return Session{
+			ID:          id,
+			GUID:        GUID,
+			TokenHash:   "hash of " + id,
+			TokenHasher: "bcrypt",
+			ExpiresAt:   now.Add(time.Hour),
+			Ip:          "127.0.0.1",
+			CreatedAt:   now,
+			ClientID:    "mobile",
+		}
+	}
+
+	t.Run("Create and get", func(t *testing.T) {
+		store := open(t)
+
+		session := newSession("session", "guid")
+		session.DPoPJKT = "jkt"
+		session.CertThumbprint = "x5t"
+		session.UserAgent = "curl/8.0"
+		session.DeviceName = "laptop"
+
+		if err := store.Create(session); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.Create(session); err == nil {
+			t.Fatalf("session with same id was created twice")
+		}
+
+		stored, err := store.Get("session")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !stored.ExpiresAt.Equal(session.ExpiresAt) || !stored.CreatedAt.Equal(session.CreatedAt) {
+			t.Fatalf("stored times differ: %v %v", stored.ExpiresAt, stored.CreatedAt)
+		}
+
+		stored.ExpiresAt = session.ExpiresAt
+		stored.CreatedAt = session.CreatedAt
+
+		if stored != session {
+			t.Fatalf("stored session differs:\n%#v\n%#v", stored, session)
+		}
+
+		if !stored.LastRefreshedAt.IsZero() {
+			t.Fatalf("new session was refreshed at: %v", stored.LastRefreshedAt)
+		}
+
+		if stored.Revoked() || !stored.Active(now) || stored.Active(now.Add(time.Hour)) {
+			t.Fatalf("unexpected state of stored session: %#v", stored)
+		}
+
+		if _, err := store.Get("unknown"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got: %v", err)
+		}
+	})
+
+	t.Run("Local time zone", func(t *testing.T) {
+		local := time.Local
+		time.Local = time.FixedZone("UTC+3", 3*60*60)
+
+		defer func() { time.Local = local }()
+
+		store := open(t)
+
+		localNow := now.In(time.Local)
+
+		session := newSession("session", "guid")
+		session.CreatedAt = localNow
+		session.ExpiresAt = localNow.Add(time.Hour)
+
+		if err := store.Create(session); err != nil {
+			t.Fatal(err)
+		}
+
+		next := Session{TokenHash: "next hash", TokenHasher: "bcrypt", ExpiresAt: localNow.Add(2 * time.Hour)}
+
+		if err := store.Rotate(session, next, localNow.Add(time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+
+		stored, err := store.Get("session")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !stored.CreatedAt.Equal(localNow) || !stored.ExpiresAt.Equal(next.ExpiresAt) || !stored.LastRefreshedAt.Equal(localNow.Add(time.Minute)) {
+			t.Fatalf("stored times are shifted: %v %v %v", stored.CreatedAt, stored.ExpiresAt, stored.LastRefreshedAt)
+		}
+
+		rotated, err := store.GetRotated("session", 0)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !rotated.RotatedAt.Equal(localNow.Add(time.Minute)) {
+			t.Fatalf("rotation time is shifted: %v", rotated.RotatedAt)
+		}
+
+		if listed, err := store.List("guid", localNow.Add(2*time.Hour-time.Minute)); err != nil || len(listed) != 1 {
+			t.Fatalf("session must be active until it expires: %#v %v", listed, err)
+		}
+
+		if listed, err := store.List("guid", localNow.Add(2*time.Hour+time.Minute)); err != nil || len(listed) != 0 {
+			t.Fatalf("session must not be active after it expires: %#v %v", listed, err)
+		}
+
+		if err := store.Revoke("session", localNow.Add(30*time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+
+		if purged, err := store.Purge(localNow, localNow.Add(29*time.Minute), 0); err != nil || purged != 0 {
+			t.Fatalf("session was purged before it was revoked: %v %v", purged, err)
+		}
+
+		if purged, err := store.Purge(localNow, localNow.Add(31*time.Minute), 0); err != nil || purged != 1 {
+			t.Fatalf("revoked session was not purged: %v %v", purged, err)
+		}
+	})
+
+	t.Run("Session without creation time", func(t *testing.T) {
+		store := open(t)
+
+		session := newSession("session", "guid")
+		session.CreatedAt = time.Time{}
+
+		if err := store.Create(session); err != nil {
+			t.Fatal(err)
+		}
+
+		stored, err := store.Get("session")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !stored.CreatedAt.IsZero() {
+			t.Fatalf("unexpected creation time: %v", stored.CreatedAt)
+		}
+	})
+
+	t.Run("Rotate", func(t *testing.T) {
+		store := open(t)
+
+		current := newSession("session", "guid")
+
+		if err := store.Create(current); err != nil {
+			t.Fatal(err)
+		}
+
+		next := Session{TokenHash: "next hash", TokenHasher: "hmac-sha256", Ip: "10.0.0.1", ExpiresAt: now.Add(2 * time.Hour), UserAgent: "browser", DeviceName: "phone"}
+
+		if err := store.Rotate(current, next, now.Add(time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.Rotate(current, next, now); !errors.Is(err, ErrConcurrentRotation) {
+			t.Fatalf("stale rotation must fail with ErrConcurrentRotation, got: %v", err)
+		}
+
+		stored, err := store.Get("session")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if stored.Generation != 1 || stored.TokenHash != next.TokenHash || stored.TokenHasher != next.TokenHasher || stored.Ip != next.Ip || !stored.ExpiresAt.Equal(next.ExpiresAt) {
+			t.Fatalf("session was not rotated: %#v", stored)
+		}
+
+		if !stored.LastRefreshedAt.Equal(now.Add(time.Minute)) || stored.UserAgent != next.UserAgent || stored.DeviceName != next.DeviceName {
+			t.Fatalf("metadata of session was not updated: %#v", stored)
+		}
+
+		if stored.GUID != current.GUID || stored.ClientID != current.ClientID || !stored.CreatedAt.Equal(current.CreatedAt) {
+			t.Fatalf("rotation changed session identity: %#v", stored)
+		}
+
+		rotated, err := store.GetRotated("session", 0)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if rotated.TokenHash != current.TokenHash || rotated.TokenHasher != current.TokenHasher || !rotated.RotatedAt.Equal(now.Add(time.Minute)) {
+			t.Fatalf("unexpected rotated token: %#v", rotated)
+		}
+
+		if _, err := store.GetRotated("session", 1); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound for current generation, got: %v", err)
+		}
+
+		if err := store.Revoke("session", now); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.Rotate(stored, next, now); !errors.Is(err, ErrConcurrentRotation) {
+			t.Fatalf("rotation of revoked session must fail with ErrConcurrentRotation, got: %v", err)
+		}
+	})
+
+	t.Run("Concurrent rotation", func(t *testing.T) {
+		store := open(t)
+
+		current := newSession("session", "guid")
+
+		if err := store.Create(current); err != nil {
+			t.Fatal(err)
+		}
+
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		rotated := 0
+
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+
+			go func(i int) {
+				defer wg.Done()
+
+				next := Session{TokenHash: fmt.Sprintf("hash %v", i), TokenHasher: "bcrypt", ExpiresAt: now.Add(time.Hour)}
+				err := store.Rotate(current, next, now)
+
+				if err != nil && !errors.Is(err, ErrConcurrentRotation) {
+					t.Errorf("unexpected rotation error: %v", err)
+				}
+
+				if err == nil {
+					mu.Lock()
+					rotated++
+					mu.Unlock()
+				}
+			}(i)
+		}
+
+		wg.Wait()
+
+		if rotated != 1 {
+			t.Fatalf("expected exactly one rotation, got %v", rotated)
+		}
+	})
+
+	t.Run("Revoke", func(t *testing.T) {
+		store := open(t)
+
+		for _, session := range []Session{newSession("first", "guid"), newSession("second", "guid"), newSession("third", "guid"), newSession("another", "another")} {
+			if err := store.Create(session); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := store.Revoke("first", now); err != nil {
+			t.Fatal(err)
+		}
+
+		// Repeated revocation keeps moment of first one
+		if err := store.Revoke("first", now.Add(time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+
+		first, err := store.Get("first")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !first.Revoked() || !first.RevokedAt.Equal(now) || first.Active(now) {
+			t.Fatalf("session was not revoked: %#v", first)
+		}
+
+		revoked, err := store.RevokeUser("guid", "third", now)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if revoked != 1 {
+			t.Fatalf("expected one revoked session, got %v", revoked)
+		}
+
+		for id, expected := range map[string]bool{"first": true, "second": true, "third": false, "another": false} {
+			session, err := store.Get(id)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if session.Revoked() != expected {
+				t.Fatalf("session %v revoked: %v, expected %v", id, session.Revoked(), expected)
+			}
+		}
+
+		if revoked, err = store.RevokeUser("guid", "", now); err != nil || revoked != 1 {
+			t.Fatalf("expected one revoked session, got %v %v", revoked, err)
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		store := open(t)
+
+		sessions := []Session{newSession("b", "guid"), newSession("a", "guid"), newSession("revoked", "guid"), newSession("expired", "guid"), newSession("another", "another")}
+		sessions[0].CreatedAt = now.Add(-time.Minute)
+		sessions[3].ExpiresAt = now
+
+		for _, session := range sessions {
+			if err := store.Create(session); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := store.Revoke("revoked", now); err != nil {
+			t.Fatal(err)
+		}
+
+		listed, err := store.List("guid", now)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(listed) != 2 || listed[0].ID != "b" || listed[1].ID != "a" {
+			t.Fatalf("unexpected sessions: %#v", listed)
+		}
+
+		if listed, err = store.List("unknown", now); err != nil || len(listed) != 0 {
+			t.Fatalf("unexpected sessions of unknown user: %#v %v", listed, err)
+		}
+	})
+
+	t.Run("Create limited", func(t *testing.T) {
+		store := open(t)
+
+		// "old" was created first but refreshed last, "idle" is least recently refreshed
+		old := newSession("old", "guid")
+		old.CreatedAt = now.Add(-2 * time.Hour)
+		idle := newSession("idle", "guid")
+		idle.CreatedAt = now.Add(-time.Hour)
+		expired := newSession("expired", "guid")
+		expired.ExpiresAt = now
+
+		for _, session := range []Session{old, idle, expired, newSession("another", "another")} {
+			if err := store.Create(session); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := store.Rotate(old, Session{TokenHash: "next hash", TokenHasher: "bcrypt", ExpiresAt: now.Add(time.Hour)}, now.Add(-time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+
+		reject := SessionLimit{Max: 2, Policy: LimitReject}
+
+		if _, err := store.CreateLimited(newSession("rejected", "guid"), reject, now); !errors.Is(err, ErrSessionLimit) {
+			t.Fatalf("expected ErrSessionLimit, got: %v", err)
+		}
+
+		if _, err := store.Get("rejected"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("rejected session was stored: %v", err)
+		}
+
+		if evicted, err := store.CreateLimited(newSession("other user", "another"), reject, now); err != nil || len(evicted) != 0 {
+			t.Fatalf("sessions of another user were counted: %#v %v", evicted, err)
+		}
+
+		evicted, err := store.CreateLimited(newSession("new", "guid"), SessionLimit{Max: 2, Policy: LimitEvict}, now)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(evicted) != 1 || evicted[0].ID != "idle" || !evicted[0].RevokedAt.Equal(now) {
+			t.Fatalf("expected least recently refreshed session to be evicted, got: %#v", evicted)
+		}
+
+		listed, err := store.List("guid", now)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(listed) != 2 || listed[0].ID != "old" || listed[1].ID != "new" {
+			t.Fatalf("unexpected sessions: %#v", listed)
+		}
+
+		if _, err := store.CreateLimited(newSession("unlimited", "guid"), SessionLimit{}, now); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("Purge", func(t *testing.T) {
+		store := open(t)
+
+		sessions := []Session{newSession("active", "guid"), newSession("expired", "guid"), newSession("long expired", "guid"), newSession("revoked", "guid"), newSession("just expired", "guid"), newSession("just revoked", "guid")}
+		sessions[1].ExpiresAt = now.Add(-time.Hour)
+		sessions[2].ExpiresAt = now.Add(-2 * time.Hour)
+		sessions[4].ExpiresAt = now.Add(time.Minute)
+
+		for _, session := range sessions {
+			if err := store.Create(session); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := store.Rotate(sessions[1], Session{TokenHash: "next", TokenHasher: "bcrypt", ExpiresAt: sessions[1].ExpiresAt}, now.Add(-2*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.Revoke("revoked", now.Add(-2*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+
+		// Revoked sessions are kept during retention period
+		if err := store.Revoke("just revoked", now.Add(-time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+
+		revokedBefore := now.Add(-time.Hour)
+
+		purged, err := store.Purge(now, revokedBefore, 1)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if purged != 1 {
+			t.Fatalf("expected one purged session, got %v", purged)
+		}
+
+		if _, err := store.Get("long expired"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("oldest expired session must be purged first: %v", err)
+		}
+
+		if purged, err = store.Purge(now, revokedBefore, 0); err != nil || purged != 2 {
+			t.Fatalf("expected two purged sessions, got %v %v", purged, err)
+		}
+
+		for id, exists := range map[string]bool{"active": true, "expired": false, "revoked": false, "just expired": true, "just revoked": true} {
+			if _, err := store.Get(id); (err == nil) != exists {
+				t.Fatalf("session %v exists: %v, expected %v", id, err == nil, exists)
+			}
+		}
+
+		if _, err := store.GetRotated("expired", 0); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("rotated tokens of purged session were kept: %v", err)
+		}
+
+		if purged, err = store.Purge(now, revokedBefore, 0); err != nil || purged != 0 {
+			t.Fatalf("expected nothing to purge, got %v %v", purged, err)
+		}
+	})
+}
+
+func TestMemoryStore(t *testing.T) {
+	testSessionStore(t, func(t *testing.T) SessionStore {
+		return NewMemoryStore()
+	})
+}
+
+// openTestSQLite opens migrated SQLite database in memory
+func openTestSQLite(t *testing.T) *SQLStore {
+	t.Helper()
+
+	store, err := OpenSQLite(":memory:")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { store.Close() })
+
+	if _, err := store.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+
+	return store
+}
+
+func TestSQLiteStore(t *testing.T) {
+	testSessionStore(t, func(t *testing.T) SessionStore {
+		return openTestSQLite(t)
+	})
+}
+
+// TestPostgresStore runs against database passed in TEST_POSTGRES_URL variable
+func TestPostgresStore(t *testing.T) {
+	url := os.Getenv("TEST_POSTGRES_URL")
+
+	if url == "" {
+		t.Skip("TEST_POSTGRES_URL is not set")
+	}
+
+	testSessionStore(t, func(t *testing.T) SessionStore {
+		db, err := sql.Open("postgres", url)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		store := NewPostgresStore(db)
+
+		t.Cleanup(func() { store.Close() })
+
+		if _, err := store.MigrateUp(); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, table := range []string{"rotated_refresh_tokens", "sessions"} {
+			if _, err := db.Exec("DELETE FROM " + table); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		return store
+	})
+}